@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const requestIDContextKey contextKey = "request_id"
+
+// requestIDFromContext extrai o request-id gerado pelo accessLogMiddleware,
+// para que os ChatBackends (ex.: pythonSDKBackend.Stream) possam logar
+// correlacionado com o Go/Python hop.
+func requestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+const accessLogFieldsContextKey contextKey = "access_log_fields"
+
+// accessLogFields carrega campos preenchidos pelo handler (ex.: /api/chat)
+// depois que a requisição já foi roteada - o ponteiro é guardado no contexto
+// por accessLogMiddleware e lido de volta só depois que next() retorna, então
+// qualquer setter chamado durante o processamento (inclusive por uma
+// goroutine que sobrevive ao ctx original, como processQueue) chega a tempo
+// de entrar na linha de log.
+type accessLogFields struct {
+	mu          sync.Mutex
+	sessionID   string
+	queueWaitMs float64
+}
+
+func accessLogFieldsFromContext(ctx context.Context) *accessLogFields {
+	fields, _ := ctx.Value(accessLogFieldsContextKey).(*accessLogFields)
+	return fields
+}
+
+// setAccessLogSessionID registra o session_id resolvido por /api/chat na
+// linha de access-log desta requisição.
+func setAccessLogSessionID(ctx context.Context, sessionID string) {
+	if fields := accessLogFieldsFromContext(ctx); fields != nil {
+		fields.mu.Lock()
+		fields.sessionID = sessionID
+		fields.mu.Unlock()
+	}
+}
+
+// setAccessLogQueueWait registra quanto tempo uma mensagem esperou na fila
+// entre ser enfileirada e processQueue começar a processá-la.
+func setAccessLogQueueWait(ctx context.Context, wait time.Duration) {
+	if fields := accessLogFieldsFromContext(ctx); fields != nil {
+		fields.mu.Lock()
+		fields.queueWaitMs = float64(wait.Microseconds()) / 1000.0
+		fields.mu.Unlock()
+	}
+}
+
+// accessLogEntry é a linha estruturada emitida por requisição, pensada para
+// ser parseada por agregadores de log em vez de grep em linhas com emoji.
+type accessLogEntry struct {
+	Time         string  `json:"time"`
+	Method       string  `json:"method"`
+	Path         string  `json:"path"`
+	Status       int     `json:"status"`
+	DurationMs   float64 `json:"duration_ms"`
+	RemoteIP     string  `json:"remote_ip"`
+	UserAgent    string  `json:"user_agent"`
+	BytesWritten int     `json:"bytes_written"`
+	RequestID    string  `json:"request_id"`
+	SessionID    string  `json:"session_id,omitempty"`
+	QueueWaitMs  float64 `json:"queue_wait_ms,omitempty"`
+}
+
+// responseRecorder envolve http.ResponseWriter para capturar status e bytes
+// escritos, já que o http.ResponseWriter padrão não expõe esses valores.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// accessLogMiddleware gera um request-id, o propaga via contexto para que
+// os ChatBackends possam logar junto com o session-id, captura status/bytes
+// da resposta e emite uma linha JSON estruturada por requisição. Substitui os
+// log.Printf com emoji ad-hoc por algo machine-parseable e correlacionável.
+func accessLogMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		fields := &accessLogFields{}
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		ctx = context.WithValue(ctx, accessLogFieldsContextKey, fields)
+		r = r.WithContext(ctx)
+
+		rr := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rr, r)
+
+		fields.mu.Lock()
+		sessionID, queueWaitMs := fields.sessionID, fields.queueWaitMs
+		fields.mu.Unlock()
+
+		entry := accessLogEntry{
+			Time:         start.UTC().Format(time.RFC3339Nano),
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			Status:       rr.status,
+			DurationMs:   float64(time.Since(start).Microseconds()) / 1000.0,
+			RemoteIP:     r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			BytesWritten: rr.bytes,
+			RequestID:    requestID,
+			SessionID:    sessionID,
+			QueueWaitMs:  queueWaitMs,
+		}
+
+		if data, err := json.Marshal(entry); err == nil {
+			log.Println(string(data))
+		}
+	}
+}
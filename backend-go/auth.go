@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// authCookieName é o cookie que carrega o token de autenticação stateless.
+const authCookieName = "auth_token"
+
+// authTokenTTL é a validade de um token emitido por /api/auth/login.
+const authTokenTTL = 24 * time.Hour
+
+// authToken é o payload cifrado dentro do cookie. Não há tabela de sessão no
+// servidor: qualquer réplica consegue validar o token sozinha, só com a chave.
+type authToken struct {
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	Exp       int64    `json:"exp"`
+	CSRFNonce string   `json:"csrf_nonce"`
+}
+
+func (t authToken) expired() bool {
+	return time.Now().Unix() >= t.Exp
+}
+
+// authTokenKeys lê as chaves de cifra do ambiente. AUTH_TOKEN_KEY é usada para
+// emitir e validar tokens novos; AUTH_TOKEN_KEY_PREVIOUS (opcional) continua
+// validando tokens emitidos com a chave anterior durante a janela de rotação -
+// depois que todo cookie com a chave antiga expirar (authTokenTTL), ela pode
+// ser removida do ambiente.
+func authTokenKeys() (current [32]byte, ok bool, previous *[32]byte) {
+	current, ok = decodeAuthKey(os.Getenv("AUTH_TOKEN_KEY"))
+	if !ok {
+		return current, false, nil
+	}
+
+	if raw := os.Getenv("AUTH_TOKEN_KEY_PREVIOUS"); raw != "" {
+		if key, ok := decodeAuthKey(raw); ok {
+			previous = &key
+		}
+	}
+
+	return current, true, previous
+}
+
+func decodeAuthKey(encoded string) (key [32]byte, ok bool) {
+	if encoded == "" {
+		return key, false
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Printf("❌ chave de auth inválida (não é base64): %v", err)
+		return key, false
+	}
+	if len(raw) != 32 {
+		log.Printf("❌ chave de auth deve ter 32 bytes, tem %d", len(raw))
+		return key, false
+	}
+
+	copy(key[:], raw)
+	return key, true
+}
+
+// sealAuthToken cifra o token com AES-GCM: o nonce aleatório prefixa o
+// ciphertext, e a tag de autenticação do GCM garante que o cookie não possa
+// ser forjado ou adulterado sem a chave - substitui o HMAC separado que um
+// esquema de cifra não autenticada exigiria.
+func sealAuthToken(tok authToken, key [32]byte) (string, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("erro ao gerar nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openAuthToken tenta decifrar com a chave atual e, se falhar, com a anterior
+// (janela de rotação). Retorna erro se nenhuma das duas validar o token.
+func openAuthToken(cookieValue string) (authToken, error) {
+	current, ok, previous := authTokenKeys()
+	if !ok {
+		return authToken{}, fmt.Errorf("AUTH_TOKEN_KEY não configurada")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(cookieValue)
+	if err != nil {
+		return authToken{}, fmt.Errorf("cookie malformado")
+	}
+
+	for _, key := range [][32]byte{current} {
+		if tok, err := openAuthTokenWithKey(sealed, key); err == nil {
+			return tok, nil
+		}
+	}
+	if previous != nil {
+		if tok, err := openAuthTokenWithKey(sealed, *previous); err == nil {
+			return tok, nil
+		}
+	}
+
+	return authToken{}, fmt.Errorf("token inválido ou expirado")
+}
+
+func openAuthTokenWithKey(sealed []byte, key [32]byte) (authToken, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return authToken{}, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return authToken{}, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return authToken{}, fmt.Errorf("token curto demais")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return authToken{}, fmt.Errorf("falha ao abrir token: %w", err)
+	}
+
+	var tok authToken
+	if err := json.Unmarshal(plain, &tok); err != nil {
+		return authToken{}, err
+	}
+	if tok.expired() {
+		return authToken{}, fmt.Errorf("token expirado")
+	}
+	return tok, nil
+}
+
+// authContextKey identifica o authToken da requisição atual no context,
+// preenchido por authMiddleware e lido por handleAuthWhoami e pelo check de CSRF.
+type authContextKeyType struct{}
+
+var authContextKey = authContextKeyType{}
+
+func authTokenFromContext(r *http.Request) (authToken, bool) {
+	tok, ok := r.Context().Value(authContextKey).(authToken)
+	return tok, ok
+}
+
+// authMiddleware verifica o cookie auth_token assinado/cifrado em vez de
+// manter estado de sessão no servidor. Se AUTH_TOKEN_KEY não estiver
+// configurada, a autenticação é pulada (dev mode), igual ao comportamento
+// anterior baseado em API_KEY.
+func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok, _ := authTokenKeys(); !ok {
+			next(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(authCookieName)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tok, err := openAuthToken(cookie.Value)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			log.Printf("⚠️  Acesso não autorizado: %s (%v)", r.RemoteAddr, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authContextKey, tok)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// csrfMiddleware aplica o double-submit cookie check em mutações: o cliente
+// precisa ecoar o csrf_nonce embutido no cookie cifrado via X-CSRF-Token, algo
+// que um atacante cross-site não consegue ler. Deve ser envolvido por
+// authMiddleware (precisa do authToken já decodificado no context).
+func csrfMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok, _ := authTokenKeys(); !ok {
+			next(w, r)
+			return
+		}
+
+		tok, ok := authTokenFromContext(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Header.Get("X-CSRF-Token") != tok.CSRFNonce {
+			http.Error(w, "CSRF token inválido", http.StatusForbidden)
+			log.Printf("⚠️  CSRF rejeitado: %s", r.RemoteAddr)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+type authLoginRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// handleAuthLogin emite o cookie de autenticação, com os escopos resolvidos
+// a partir da política (policy.go). A própria requisição de login continua
+// protegida por X-API-Key (se API_KEY estiver configurada) - não há cadastro
+// de usuários neste backend, então a API key é quem decide se o user_id
+// solicitado pode ser emitido.
+func handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if apiKey := os.Getenv("API_KEY"); apiKey != "" {
+		if r.Header.Get("X-API-Key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var req authLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "user_id é obrigatório", http.StatusBadRequest)
+		return
+	}
+
+	key, ok, _ := authTokenKeys()
+	if !ok {
+		http.Error(w, "AUTH_TOKEN_KEY não configurada", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Os escopos vêm da política (policy.go), não do corpo da requisição - um
+	// cliente não pode se auto-conceder escopos só pedindo por eles no login.
+	tok := authToken{
+		UserID:    req.UserID,
+		Scopes:    scopesForUser(req.UserID),
+		Exp:       time.Now().Add(authTokenTTL).Unix(),
+		CSRFNonce: uuid.New().String(),
+	}
+
+	sealed, err := sealAuthToken(tok, key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("erro ao emitir token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     authCookieName,
+		Value:    sealed,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(authTokenTTL.Seconds()),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":    tok.UserID,
+		"scopes":     tok.Scopes,
+		"csrf_token": tok.CSRFNonce,
+		"expires_at": tok.Exp,
+	})
+}
+
+// handleAuthLogout apaga o cookie. Como não há estado de sessão no servidor,
+// não existe nada além disso para invalidar - o token seguiria válido até
+// expirar se o cliente o reenviasse manualmente, o mesmo trade-off de
+// qualquer esquema puramente stateless.
+func handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     authCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAuthWhoami expõe o token decodificado da requisição atual, para que o
+// frontend saiba quem está logado e quais escopos tem sem precisar decifrar
+// o cookie.
+func handleAuthWhoami(w http.ResponseWriter, r *http.Request) {
+	tok, ok := authTokenFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":    tok.UserID,
+		"scopes":     tok.Scopes,
+		"expires_at": tok.Exp,
+	})
+}
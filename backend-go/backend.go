@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChatBackend abstrai de onde vem a resposta do assistente: o proxy para o
+// Python SDK, o CLI local (o comportamento pré-proxy, indicado pelo
+// comentário original "Usando CLI do Claude" em main.go) e a Messages API da
+// Anthropic, um terceiro caminho direto, sem depender de nenhum processo
+// auxiliar.
+type ChatBackend interface {
+	// Stream inicia um turno e devolve um canal de sseEvent fechado quando o
+	// turno termina. Um erro aqui significa falha de conexão (antes de
+	// qualquer evento ter sido emitido) e dispara failover para o próximo
+	// backend da cadeia; uma vez que o canal é devolvido, erros do próprio
+	// turno são emitidos como sseEvent{eventType: "error"} nele.
+	Stream(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) (<-chan sseEvent, error)
+	// Name identifica o backend nos logs.
+	Name() string
+}
+
+// activeChatBackends é a cadeia de prioridade resolvida na inicialização a
+// partir de CHAT_BACKEND (ex: "python,cli" tenta CLI se o Python falhar).
+var activeChatBackends = chatBackendsFromEnv()
+
+func chatBackendsFromEnv() []ChatBackend {
+	selected := os.Getenv("CHAT_BACKEND")
+	if selected == "" {
+		selected = "python"
+	}
+
+	var chain []ChatBackend
+	for _, name := range strings.Split(selected, ",") {
+		switch strings.TrimSpace(name) {
+		case "python":
+			chain = append(chain, newPythonSDKBackend())
+		case "cli":
+			chain = append(chain, newLocalCLIBackend())
+		case "anthropic":
+			chain = append(chain, newAnthropicAPIBackend())
+		default:
+			log.Printf("⚠️  CHAT_BACKEND desconhecido: %q (ignorando)", name)
+		}
+	}
+
+	if len(chain) == 0 {
+		chain = append(chain, newPythonSDKBackend())
+	}
+
+	return chain
+}
+
+// streamWithFailover tenta cada backend da cadeia em ordem. Um erro de
+// conexão avança para o próximo backend, de forma que um Python SDK
+// derrubado não tire o chat inteiro do ar quando há um backend alternativo
+// configurado.
+func streamWithFailover(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) <-chan sseEvent {
+	var lastErr error
+
+	for _, backend := range activeChatBackends {
+		events, err := backend.Stream(ctx, req, sessionID, projectDir, sessionExists)
+		if err == nil {
+			return events
+		}
+		log.Printf("⚠️  Backend %s falhou ao conectar: %v", backend.Name(), err)
+		lastErr = err
+	}
+
+	failed := make(chan sseEvent, 1)
+	failed <- sseEvent{eventType: "error", content: fmt.Sprintf("todos os backends de chat falharam: %v", lastErr)}
+	close(failed)
+	return failed
+}
+
+// pythonSDKBackend é o comportamento atual: proxy HTTP/SSE para o backend
+// Python que usa o SDK oficial do Claude.
+type pythonSDKBackend struct {
+	url string
+}
+
+func newPythonSDKBackend() *pythonSDKBackend {
+	url := os.Getenv("PYTHON_SDK_URL")
+	if url == "" {
+		url = "http://localhost:8080/api/chat"
+	}
+	return &pythonSDKBackend{url: url}
+}
+
+func (b *pythonSDKBackend) Name() string { return "python" }
+
+func (b *pythonSDKBackend) Stream(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) (<-chan sseEvent, error) {
+	log.Printf("🔄 Proxy para Python SDK - Sessão: %s (request_id: %s)", sessionID, requestIDFromContext(ctx))
+
+	// Extrair project_id do projectDir
+	// projectDir = /Users/2a/.claude/projetos/teste-memoria
+	// project_id deve ser apenas o nome final: teste-memoria
+	projectID := filepath.Base(projectDir)
+	log.Printf("📦 project_id extraído: %s (de %s)", projectID, projectDir)
+
+	payload := map[string]interface{}{
+		"message":    req.Message,
+		"session_id": sessionID,
+		"project_id": projectID,
+		"cwd":        projectDir, // Caminho completo do projeto para o SDK usar como working directory
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar payload: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", b.url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{} // Sem timeout - a deadline é gerenciada por upstreamStream
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar com Python: %w", err)
+	}
+
+	eventChan := make(chan sseEvent, 100)
+	go b.pump(ctx, resp, sessionID, eventChan)
+	return eventChan, nil
+}
+
+func (b *pythonSDKBackend) pump(ctx context.Context, resp *http.Response, sessionID string, eventChan chan<- sseEvent) {
+	defer close(eventChan)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		pythonUpstreamErrorsTotal.WithLabelValues(fmt.Sprintf("%d", resp.StatusCode)).Inc()
+		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro HTTP %d: %s", resp.StatusCode, string(body))}
+		return
+	}
+
+	// Idle timeout e deadline total: se o SDK travar no meio do stream, o
+	// timer fecha o corpo da resposta em vez de deixar a goroutine leitora
+	// presa para sempre e o slot da fila bloqueado.
+	stream := newUpstreamStream(upstreamIdleTimeout(), upstreamTotalTimeout(), func() {
+		log.Printf("⏱️  Deadline total do turno excedida - sessão: %s", sessionID)
+		resp.Body.Close()
+	})
+	defer stream.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	// lines/readErr usam buffer 1 e selecionam em pumpDone: o select externo
+	// pode sair via stream.Idle()/ctx.Done() sem nunca mais ler desses canais,
+	// e sem isso a goroutine leitora vazaria presa para sempre em "lines <- line"
+	// se terminasse um ReadString bem-sucedido exatamente nesse instante.
+	lines := make(chan string, 1)
+	readErr := make(chan error, 1)
+	pumpDone := make(chan struct{})
+	defer close(pumpDone)
+
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-pumpDone:
+					return
+				}
+			}
+			if err != nil {
+				select {
+				case readErr <- err:
+				case <-pumpDone:
+				}
+				return
+			}
+		}
+	}()
+
+readLoop:
+	for {
+		select {
+		case line := <-lines:
+			stream.SetReadDeadline()
+
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			if strings.HasPrefix(line, "data: ") {
+				dataStr := strings.TrimPrefix(line, "data: ")
+
+				var data map[string]interface{}
+				if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+					log.Printf("Erro ao parsear JSON: %v", err)
+					continue
+				}
+
+				eventType, _ := data["type"].(string)
+
+				if eventType == "text" || eventType == "content" {
+					if content, ok := data["content"].(string); ok {
+						eventChan <- sseEvent{eventType: "text", content: content}
+					} else if text, ok := data["text"].(string); ok {
+						eventChan <- sseEvent{eventType: "text", content: text}
+					}
+				} else if eventType == "done" || eventType == "session_created" {
+					eventChan <- sseEvent{eventType: "done", sessionID: &sessionID}
+				} else if eventType == "error" {
+					if errorMsg, ok := data["error"].(string); ok {
+						eventChan <- sseEvent{eventType: "error", content: errorMsg}
+					}
+				}
+			}
+		case err := <-readErr:
+			if err != io.EOF {
+				log.Printf("Erro ao ler stream: %v", err)
+			}
+			break readLoop
+		case <-stream.Idle():
+			log.Printf("⏱️  Idle timeout no stream do upstream Python - sessão: %s", sessionID)
+			eventChan <- sseEvent{eventType: "error", content: "upstream idle timeout"}
+			resp.Body.Close()
+			break readLoop
+		case <-ctx.Done():
+			resp.Body.Close()
+			break readLoop
+		}
+	}
+
+	log.Printf("✅ Proxy Python finalizado para sessão: %s", sessionID)
+}
+
+// localCLIBackend executa `claude` localmente com --continue/--session-id e
+// faz parsing do stdout em stream-json, o comportamento original de antes do
+// proxy Python existir.
+type localCLIBackend struct {
+	binary string
+}
+
+func newLocalCLIBackend() *localCLIBackend {
+	binary := os.Getenv("CLAUDE_CLI_BIN")
+	if binary == "" {
+		binary = "claude"
+	}
+	return &localCLIBackend{binary: binary}
+}
+
+func (b *localCLIBackend) Name() string { return "cli" }
+
+func (b *localCLIBackend) Stream(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) (<-chan sseEvent, error) {
+	args := []string{"--print", "--output-format", "stream-json", "--session-id", sessionID}
+	if sessionExists {
+		args = append(args, "--continue")
+	}
+
+	cmd := exec.CommandContext(ctx, b.binary, args...)
+	cmd.Dir = projectDir
+	cmd.Stdin = strings.NewReader(req.Message)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir stdout do claude CLI: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("erro ao iniciar claude CLI: %w", err)
+	}
+
+	eventChan := make(chan sseEvent, 100)
+	go b.pump(cmd, stdout, sessionID, eventChan)
+	return eventChan, nil
+}
+
+func (b *localCLIBackend) pump(cmd *exec.Cmd, stdout io.ReadCloser, sessionID string, eventChan chan<- sseEvent) {
+	defer close(eventChan)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &data); err != nil {
+			log.Printf("Erro ao parsear linha do CLI: %v", err)
+			continue
+		}
+
+		switch data["type"] {
+		case "assistant":
+			if text := extractCLIAssistantText(data); text != "" {
+				eventChan <- sseEvent{eventType: "text", content: text}
+			}
+		case "result":
+			eventChan <- sseEvent{eventType: "done", sessionID: &sessionID}
+		case "error":
+			if msg, ok := data["message"].(string); ok {
+				eventChan <- sseEvent{eventType: "error", content: msg}
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		log.Printf("⚠️  claude CLI finalizou com erro: %v", err)
+	}
+}
+
+// extractCLIAssistantText concatena os blocos de texto de uma mensagem
+// "assistant" do stream-json do claude CLI.
+func extractCLIAssistantText(data map[string]interface{}) string {
+	message, ok := data["message"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	blocks, ok := message["content"].([]interface{})
+	if !ok {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, block := range blocks {
+		m, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t, _ := m["type"].(string); t == "text" {
+			if text, ok := m["text"].(string); ok {
+				sb.WriteString(text)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// anthropicAPIBackend chama a Messages API oficial da Anthropic diretamente,
+// sem depender do proxy Python nem de um binário claude local.
+type anthropicAPIBackend struct {
+	apiKey string
+	model  string
+}
+
+func newAnthropicAPIBackend() *anthropicAPIBackend {
+	model := os.Getenv("ANTHROPIC_MODEL")
+	if model == "" {
+		model = "claude-sonnet-4-5"
+	}
+	return &anthropicAPIBackend{
+		apiKey: os.Getenv("ANTHROPIC_API_KEY"),
+		model:  model,
+	}
+}
+
+func (b *anthropicAPIBackend) Name() string { return "anthropic" }
+
+// Stream carrega o histórico do .jsonl da sessão (se houver) para a Messages
+// API - ao contrário do proxy Python e do CLI local, nada além deste backend
+// mantém estado de conversa, então sem isso cada turno seria single-turn.
+// Pelo mesmo motivo, ele também é quem grava o turno de volta no .jsonl (ver
+// appendSessionMessage): não existe processo externo fazendo isso por ele.
+func (b *anthropicAPIBackend) Stream(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) (<-chan sseEvent, error) {
+	if b.apiKey == "" {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY não configurada")
+	}
+
+	sessionFile := filepath.Join(projectDir, sessionID+".jsonl")
+
+	messages := make([]map[string]string, 0, 1)
+	if sessionExists {
+		for _, h := range loadAnthropicHistory(sessionFile) {
+			messages = append(messages, map[string]string{"role": h.Role, "content": h.Content})
+		}
+	}
+	messages = append(messages, map[string]string{"role": "user", "content": req.Message})
+
+	payload := map[string]interface{}{
+		"model":      b.model,
+		"max_tokens": 4096,
+		"stream":     true,
+		"messages":   messages,
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar payload: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", b.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar com a Anthropic API: %w", err)
+	}
+
+	// Gravar o turno do usuário antes da resposta chegar, para que ele não se
+	// perca se a chamada à API falhar no meio do caminho.
+	if err := appendSessionMessage(sessionFile, "user", req.Message); err != nil {
+		log.Printf("⚠️  Erro ao persistir mensagem do usuário (backend anthropic): %v", err)
+	}
+
+	eventChan := make(chan sseEvent, 100)
+	go b.pump(ctx, resp, sessionID, sessionFile, eventChan)
+	return eventChan, nil
+}
+
+// pump usa o mesmo wrapper de idle/deadline total e o mesmo padrão
+// producer-goroutine-com-buffer que pythonSDKBackend.pump (ver backend.go e
+// o comentário de upstreamStream em deadline.go, escrito de propósito para
+// ser reaproveitado por outros transportes) - sem isso, um stall da
+// Anthropic Messages API sem fechar a conexão travaria reader.ReadString
+// para sempre, vazando a goroutine e prendendo o slot da fila dessa sessão.
+func (b *anthropicAPIBackend) pump(ctx context.Context, resp *http.Response, sessionID string, sessionFile string, eventChan chan<- sseEvent) {
+	defer close(eventChan)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro HTTP %d: %s", resp.StatusCode, string(body))}
+		return
+	}
+
+	stream := newUpstreamStream(upstreamIdleTimeout(), upstreamTotalTimeout(), func() {
+		log.Printf("⏱️  Deadline total do turno excedida - sessão: %s", sessionID)
+		resp.Body.Close()
+	})
+	defer stream.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	lines := make(chan string, 1)
+	readErr := make(chan error, 1)
+	pumpDone := make(chan struct{})
+	defer close(pumpDone)
+
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-pumpDone:
+					return
+				}
+			}
+			if err != nil {
+				select {
+				case readErr <- err:
+				case <-pumpDone:
+				}
+				return
+			}
+		}
+	}()
+
+	var fullText strings.Builder
+
+readLoop:
+	for {
+		select {
+		case line := <-lines:
+			stream.SetReadDeadline()
+
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+				continue
+			}
+
+			switch data["type"] {
+			case "content_block_delta":
+				if delta, ok := data["delta"].(map[string]interface{}); ok {
+					if text, ok := delta["text"].(string); ok {
+						fullText.WriteString(text)
+						eventChan <- sseEvent{eventType: "text", content: text}
+					}
+				}
+			case "message_stop":
+				if fullText.Len() > 0 {
+					if err := appendSessionMessage(sessionFile, "assistant", fullText.String()); err != nil {
+						log.Printf("⚠️  Erro ao persistir resposta do assistant (backend anthropic): %v", err)
+					}
+				}
+				eventChan <- sseEvent{eventType: "done", sessionID: &sessionID}
+				break readLoop
+			case "error":
+				if errObj, ok := data["error"].(map[string]interface{}); ok {
+					if msg, ok := errObj["message"].(string); ok {
+						eventChan <- sseEvent{eventType: "error", content: msg}
+					}
+				}
+			}
+		case err := <-readErr:
+			if err != io.EOF {
+				log.Printf("Erro ao ler stream da Anthropic API: %v", err)
+			}
+			break readLoop
+		case <-stream.Idle():
+			log.Printf("⏱️  Idle timeout no stream da Anthropic API - sessão: %s", sessionID)
+			eventChan <- sseEvent{eventType: "error", content: "upstream idle timeout"}
+			resp.Body.Close()
+			break readLoop
+		case <-ctx.Done():
+			resp.Body.Close()
+			break readLoop
+		}
+	}
+}
+
+// anthropicHistoryMessage é a forma mínima de que Stream precisa para
+// reconstruir o histórico a partir de um .jsonl existente: role e o texto já
+// concatenado, não importa qual backend escreveu a linha originalmente.
+type anthropicHistoryMessage struct {
+	Role    string
+	Content string
+}
+
+// loadAnthropicHistory lê e decifra (decryptSessionLineTransparent, igual aos
+// demais leitores de sessão) as linhas já persistidas de uma sessão e
+// devolve os turnos user/assistant na ordem em que ocorreram.
+func loadAnthropicHistory(sessionFile string) []anthropicHistoryMessage {
+	data, err := os.ReadFile(sessionFile)
+	if err != nil {
+		return nil
+	}
+
+	var history []anthropicHistoryMessage
+	for _, rawLine := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(rawLine) == "" {
+			continue
+		}
+
+		var parsed struct {
+			Type    string `json:"type"`
+			Message struct {
+				Role    string          `json:"role"`
+				Content json.RawMessage `json:"content"`
+			} `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(decryptSessionLineTransparent(rawLine)), &parsed); err != nil {
+			continue
+		}
+		if parsed.Type != "user" && parsed.Type != "assistant" {
+			continue
+		}
+
+		text := extractMessageText(parsed.Message.Content)
+		if text == "" {
+			continue
+		}
+		history = append(history, anthropicHistoryMessage{Role: parsed.Message.Role, Content: text})
+	}
+	return history
+}
+
+// extractMessageText lê message.content, que pode ser tanto uma string
+// simples quanto uma lista de blocos {"type":"text","text":...} (o formato
+// que o Claude CLI grava) - concatenando só o texto de ambos os formatos.
+func extractMessageText(raw json.RawMessage) string {
+	var asString string
+	if err := json.Unmarshal(raw, &asString); err == nil {
+		return asString
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, block := range blocks {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	return sb.String()
+}
+
+// appendSessionMessage grava uma linha no .jsonl da sessão no formato mínimo
+// que jsonlMessageID/fork.go sabem ler (type, message.id, message.role,
+// message.content), cifrando-a se SESSION_ENCRYPTION_KEY estiver configurada -
+// necessário porque, ao contrário dos backends CLI/Python, nenhum processo
+// externo grava a sessão por este backend.
+func appendSessionMessage(sessionFile, role, text string) error {
+	line := map[string]interface{}{
+		"type": role,
+		"message": map[string]interface{}{
+			"id":      uuid.New().String(),
+			"role":    role,
+			"content": []map[string]string{{"type": "text", "text": text}},
+		},
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar mensagem: %w", err)
+	}
+	serialized := string(data)
+
+	if key, ok := sessionEncryptionKey(); ok {
+		encrypted, err := encryptSessionLine(serialized, key)
+		if err != nil {
+			return fmt.Errorf("erro ao cifrar mensagem: %w", err)
+		}
+		serialized = encrypted
+	}
+
+	file, err := os.OpenFile(sessionFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo de sessão: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(serialized + "\n")
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// chatCancelEntry associa a função de cancelamento do turno em andamento de
+// uma sessão ao id da mensagem que a originou, para que unregisterChatCancel
+// nunca apague por engano o registro de um turno mais novo que começou logo
+// depois que o anterior terminou.
+type chatCancelEntry struct {
+	messageID string
+	project   string
+	cancel    context.CancelFunc
+}
+
+// chatCancellations mantém, por sessão, o cancelamento do turno em
+// andamento - usado tanto por DELETE /api/chat/{session_id} quanto pelo
+// watcher de desconexão do handler SSE de /api/chat. O projeto é registrado
+// aqui no momento do enqueue (não reconstruído via findSessionFile depois),
+// para que handleChatCancel consiga checar project:write mesmo no primeiro
+// turno de uma sessão nova, antes do .jsonl existir em disco.
+var chatCancellations = struct {
+	sync.Mutex
+	entries map[string]chatCancelEntry
+}{entries: make(map[string]chatCancelEntry)}
+
+func registerChatCancel(sessionID, messageID, project string, cancel context.CancelFunc) {
+	chatCancellations.Lock()
+	chatCancellations.entries[sessionID] = chatCancelEntry{messageID: messageID, project: project, cancel: cancel}
+	chatCancellations.Unlock()
+}
+
+func unregisterChatCancel(sessionID, messageID string) {
+	chatCancellations.Lock()
+	defer chatCancellations.Unlock()
+	if entry, ok := chatCancellations.entries[sessionID]; ok && entry.messageID == messageID {
+		delete(chatCancellations.entries, sessionID)
+	}
+}
+
+// cancelChatSession cancela o turno em andamento de uma sessão, se houver.
+// Retorna false se não havia nada em andamento para cancelar.
+func cancelChatSession(sessionID string) bool {
+	chatCancellations.Lock()
+	entry, ok := chatCancellations.entries[sessionID]
+	chatCancellations.Unlock()
+
+	if !ok {
+		return false
+	}
+	entry.cancel()
+	return true
+}
+
+// handleChatCancel cancela o turno em andamento de uma sessão (o processo
+// CLI filho ou a requisição HTTP upstream, via runCtx em processQueue).
+func handleChatCancel(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("session_id")
+
+	if !authorizeScope(w, r, "chat:send") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	chatCancellations.Lock()
+	entry, ok := chatCancellations.entries[sessionID]
+	chatCancellations.Unlock()
+
+	if !ok {
+		http.Error(w, "Nenhum turno em andamento para esta sessão", http.StatusNotFound)
+		return
+	}
+
+	// O projeto do registro de cancelamento (não o filesystem) é quem decide
+	// project:write aqui - do contrário, qualquer chamador com apenas
+	// chat:send poderia cancelar o turno de outro tenant só sabendo o
+	// session_id, justamente na janela (sessão nova, .jsonl ainda não
+	// gravado) em que findSessionFile nunca encontraria o projeto.
+	if !authorizeScope(w, r, projectScope("write", entry.project)) {
+		return
+	}
+
+	if !cancelChatSession(sessionID) {
+		http.Error(w, "Nenhum turno em andamento para esta sessão", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "session_id": sessionID})
+}
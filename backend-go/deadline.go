@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUpstreamIdleTimeout  = 60 * time.Second
+	defaultUpstreamTotalTimeout = 10 * time.Minute
+)
+
+// deadlineTimer é um timer reiniciável sem realocar canal a cada reset bem
+// sucedido, no padrão read-cancel-channel + time.AfterFunc usado em adapters
+// de transporte: cada reset adia o disparo, e o disparo sinaliza uma única
+// vez fechando `fired`, para que múltiplos leitores possam selecionar nele.
+type deadlineTimer struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	duration time.Duration
+	fired    chan struct{}
+	once     sync.Once
+}
+
+func newDeadlineTimer(d time.Duration) *deadlineTimer {
+	dt := &deadlineTimer{duration: d, fired: make(chan struct{})}
+	dt.timer = time.AfterFunc(d, dt.fire)
+	return dt
+}
+
+func (dt *deadlineTimer) fire() {
+	dt.once.Do(func() { close(dt.fired) })
+}
+
+// Reset adia o disparo em mais `duration`. Se Stop retornar false o timer já
+// disparou (ou está disparando agora), e não há nada a reiniciar - o
+// chamador deve tratar isso como deadline já excedida.
+func (dt *deadlineTimer) Reset() {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.timer.Stop() {
+		dt.timer.Reset(dt.duration)
+	}
+}
+
+func (dt *deadlineTimer) setDuration(d time.Duration) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.duration = d
+}
+
+func (dt *deadlineTimer) Stop() {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.timer.Stop()
+}
+
+// C é fechado quando o timer dispara.
+func (dt *deadlineTimer) C() <-chan struct{} {
+	return dt.fired
+}
+
+// upstreamStream envolve o corpo de uma resposta HTTP streaming com um idle
+// timeout (resetado a cada frame recebido) e uma deadline total de turno, de
+// forma que a goroutine leitora nunca fique presa indefinidamente se o
+// upstream (Python SDK) parar de responder no meio do stream. Exposto como um
+// tipo próprio (em vez de só os timers soltos) para que futuros transportes,
+// como o WebSocket, possam reaproveitar o mesmo wrapper.
+type upstreamStream struct {
+	idleTimer  *deadlineTimer
+	totalTimer *time.Timer
+	closeOnce  sync.Once
+	onClose    func()
+}
+
+func newUpstreamStream(idleTimeout, totalTimeout time.Duration, onTotalExceeded func()) *upstreamStream {
+	s := &upstreamStream{
+		idleTimer: newDeadlineTimer(idleTimeout),
+	}
+	s.totalTimer = time.AfterFunc(totalTimeout, onTotalExceeded)
+	return s
+}
+
+// SetReadDeadline reinicia o timer de ociosidade - chamado a cada frame lido.
+func (s *upstreamStream) SetReadDeadline() {
+	s.idleTimer.Reset()
+}
+
+// SetIdleTimeout ajusta a duração usada nos próximos resets do idle timer.
+func (s *upstreamStream) SetIdleTimeout(d time.Duration) {
+	s.idleTimer.setDuration(d)
+}
+
+// Idle é fechado quando o idle timeout dispara.
+func (s *upstreamStream) Idle() <-chan struct{} {
+	return s.idleTimer.C()
+}
+
+// Close libera os timers internos; seguro para chamar mais de uma vez.
+func (s *upstreamStream) Close() {
+	s.closeOnce.Do(func() {
+		s.idleTimer.Stop()
+		s.totalTimer.Stop()
+	})
+}
+
+func upstreamIdleTimeout() time.Duration {
+	return durationFromEnvSeconds("UPSTREAM_IDLE_TIMEOUT_SECONDS", defaultUpstreamIdleTimeout)
+}
+
+func upstreamTotalTimeout() time.Duration {
+	return durationFromEnvSeconds("UPSTREAM_TOTAL_TIMEOUT_SECONDS", defaultUpstreamTotalTimeout)
+}
+
+func durationFromEnvSeconds(envVar string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(envVar); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// encryptedLinePrefix marca uma linha cifrada com NaCl secretbox, para que os
+// leitores saibam descriptografar sem precisar saber de antemão se o arquivo
+// está cifrado - arquivos antigos continuam em texto puro e são lidos normalmente.
+const encryptedLinePrefix = "ENC1:"
+
+// sessionEncryptionKey lê SESSION_ENCRYPTION_KEY (32 bytes, base64) do
+// ambiente. Retorna ok=false quando a variável não está configurada, caso em
+// que o comportamento permanece exatamente como hoje (sem cifra).
+func sessionEncryptionKey() (key [32]byte, ok bool) {
+	encoded := os.Getenv("SESSION_ENCRYPTION_KEY")
+	if encoded == "" {
+		return key, false
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Printf("❌ SESSION_ENCRYPTION_KEY inválida (não é base64): %v", err)
+		return key, false
+	}
+	if len(raw) != 32 {
+		log.Printf("❌ SESSION_ENCRYPTION_KEY deve ter 32 bytes, tem %d", len(raw))
+		return key, false
+	}
+
+	copy(key[:], raw)
+	return key, true
+}
+
+// encryptSessionLine cifra uma linha JSONL com secretbox usando um nonce
+// aleatório de 24 bytes prefixado ao ciphertext, e codifica o resultado em
+// base64 atrás do marcador ENC1:.
+func encryptSessionLine(line string, key [32]byte) (string, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("erro ao gerar nonce: %w", err)
+	}
+
+	sealed := secretbox.Seal(nonce[:], []byte(line), &nonce, &key)
+	return encryptedLinePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSessionLine decifra uma linha produzida por encryptSessionLine. Se a
+// linha não carrega o marcador ENC1:, é devolvida sem alteração - isso é o
+// que permite conviver com sessões antigas em texto puro.
+func decryptSessionLine(line string, key [32]byte) (string, error) {
+	if !strings.HasPrefix(line, encryptedLinePrefix) {
+		return line, nil
+	}
+
+	encoded := strings.TrimPrefix(line, encryptedLinePrefix)
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("erro ao decodificar base64: %w", err)
+	}
+	if len(sealed) < 24 {
+		return "", fmt.Errorf("linha cifrada corrompida: tamanho insuficiente")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+
+	plain, ok := secretbox.Open(nil, sealed[24:], &nonce, &key)
+	if !ok {
+		return "", fmt.Errorf("falha ao decifrar: chave incorreta ou dado corrompido")
+	}
+
+	return string(plain), nil
+}
+
+// decryptSessionLineTransparent decifra a linha se houver chave configurada e
+// a linha estiver cifrada; caso contrário devolve a linha como está. Usado
+// pelos leitores de sessão para que o comportamento sem chave fique idêntico
+// ao atual.
+func decryptSessionLineTransparent(line string) string {
+	key, ok := sessionEncryptionKey()
+	if !ok {
+		return line
+	}
+
+	decrypted, err := decryptSessionLine(line, key)
+	if err != nil {
+		log.Printf("❌ Erro ao decifrar linha de sessão: %v", err)
+		return line
+	}
+	return decrypted
+}
+
+// encryptProjectSessions reescreve todos os .jsonl em texto puro de um
+// projeto para o formato cifrado, linha a linha. Sessões já cifradas (linhas
+// com o marcador ENC1:) são deixadas como estão.
+func encryptProjectSessions(projectDir string, key [32]byte) (migrated int, err error) {
+	entries, err := os.ReadDir(projectDir)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao ler diretório do projeto: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+
+		sessionFile := filepath.Join(projectDir, entry.Name())
+		if err := encryptSessionFile(sessionFile, key); err != nil {
+			return migrated, fmt.Errorf("erro ao cifrar %s: %w", entry.Name(), err)
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// encryptSessionFile reescreve um único arquivo de sessão, cifrando cada
+// linha em texto puro e preservando linhas já cifradas.
+func encryptSessionFile(sessionFile string, key [32]byte) error {
+	file, err := os.Open(sessionFile)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	scanErr := scanner.Err()
+	file.Close()
+	if scanErr != nil {
+		return scanErr
+	}
+
+	var out strings.Builder
+	for _, line := range lines {
+		if strings.HasPrefix(line, encryptedLinePrefix) {
+			out.WriteString(line)
+		} else {
+			encrypted, err := encryptSessionLine(line, key)
+			if err != nil {
+				return err
+			}
+			out.WriteString(encrypted)
+		}
+		out.WriteString("\n")
+	}
+
+	return os.WriteFile(sessionFile, []byte(out.String()), 0644)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// enrollRecord rastreia o resultado de uma tentativa de importação, seja via
+// o diretório observado ou via POST /api/enroll - para que GET
+// /api/enroll/status consiga mostrar pendências e falhas com motivo.
+type enrollRecord struct {
+	File       string    `json:"file"`
+	Project    string    `json:"project"`
+	SessionID  string    `json:"session_id,omitempty"`
+	Status     string    `json:"status"` // pending, imported, failed
+	Reason     string    `json:"reason,omitempty"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
+
+// enrollRegistry mantém o histórico de importações em memória - assim como
+// sessionCache e eventBroadcaster, não há necessidade de sobreviver a um
+// restart: um restart simplesmente reprocessa o que ainda estiver no diretório.
+var enrollRegistry = struct {
+	sync.RWMutex
+	records map[string]*enrollRecord // chave: caminho absoluto do arquivo de origem
+}{records: make(map[string]*enrollRecord)}
+
+func recordEnroll(path string, rec enrollRecord) {
+	enrollRegistry.Lock()
+	enrollRegistry.records[path] = &rec
+	enrollRegistry.Unlock()
+}
+
+func enrollDir() string {
+	if dir := os.Getenv("ENROLL_DIR_PATH"); dir != "" {
+		return dir
+	}
+	return filepath.Join(getClaudeBaseDir(), "enroll")
+}
+
+// initEnroller cria o diretório de enrollment se necessário e inicia o watch
+// recursivo (reaproveitando addWatchRecursive de watcher.go).
+func initEnroller() {
+	root := enrollDir()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		log.Printf("⚠️  Não foi possível criar diretório de enrollment (%s): %v", root, err)
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("⚠️  Não foi possível iniciar watcher de enrollment: %v", err)
+		return
+	}
+
+	addWatchRecursive(watcher, root)
+
+	// Arquivos que já estavam no diretório antes deste start (ex.: deixados
+	// durante um restart) não geram evento no fsnotify - processá-los agora.
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			processEnrollDrop(path)
+		}
+		return nil
+	})
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watcher.Add(event.Name); err != nil {
+						log.Printf("⚠️  Erro ao observar novo diretório de enrollment %s: %v", event.Name, err)
+					}
+					continue
+				}
+				processEnrollDrop(event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("❌ Erro no watcher de enrollment: %v", err)
+			}
+		}
+	}()
+
+	log.Printf("📥 Watcher de enrollment iniciado em: %s", root)
+}
+
+// enrollManifest descreve, para um lote de arquivos soltos lado a lado (ex.:
+// extraídos de um .zip), para qual projeto cada um deve ser importado -
+// usado quando os .jsonl não estão em subdiretórios nomeados pelo projeto.
+type enrollManifest struct {
+	Imports []struct {
+		Project string `json:"project"`
+		File    string `json:"file"`
+	} `json:"imports"`
+}
+
+// processEnrollDrop decide como tratar um arquivo novo em enrollDir(): um
+// manifest.json processa os imports que lista, e um .jsonl dentro de um
+// subdiretório usa o nome do subdiretório como projeto.
+func processEnrollDrop(path string) {
+	switch filepath.Ext(path) {
+	case ".json":
+		processEnrollManifest(path)
+	case ".jsonl":
+		processEnrollSessionFile(path)
+	}
+}
+
+func processEnrollManifest(manifestPath string) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return
+	}
+
+	var manifest enrollManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Printf("⚠️  Manifest de enrollment inválido (%s): %v", manifestPath, err)
+		return
+	}
+
+	dir := filepath.Dir(manifestPath)
+	for _, entry := range manifest.Imports {
+		sourcePath := filepath.Join(dir, entry.File)
+		importEnrolledSession(sourcePath, entry.Project)
+	}
+}
+
+// processEnrollSessionFile trata um .jsonl solto diretamente em enrollDir():
+// se estiver num subdiretório, o nome do subdiretório é o projeto; se
+// estiver na raiz, fica pendente até um manifest.json o referenciar.
+func processEnrollSessionFile(path string) {
+	root := enrollDir()
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return
+	}
+
+	dir := filepath.Dir(rel)
+	if dir == "." {
+		recordEnroll(path, enrollRecord{
+			File:       filepath.Base(path),
+			Status:     "pending",
+			Reason:     "aguardando manifest.json com o projeto de destino",
+			EnrolledAt: time.Now(),
+		})
+		return
+	}
+
+	importEnrolledSession(path, filepath.ToSlash(dir))
+}
+
+// importEnrolledSession valida o nome do arquivo, deduplica contra a sessão
+// já existente (se houver) e copia o .jsonl para o diretório do projeto,
+// criando-o se necessário. O arquivo de origem é removido após uma
+// importação bem-sucedida; em caso de falha, ele permanece no lugar e o
+// motivo fica disponível em GET /api/enroll/status.
+func importEnrolledSession(sourcePath string, project string) {
+	fileName := filepath.Base(sourcePath)
+	sessionID := strings.TrimSuffix(fileName, ".jsonl")
+
+	fail := func(reason string) {
+		log.Printf("❌ Falha ao importar %s: %s", fileName, reason)
+		recordEnroll(sourcePath, enrollRecord{
+			File: fileName, Project: project, SessionID: sessionID,
+			Status: "failed", Reason: reason, EnrolledAt: time.Now(),
+		})
+	}
+
+	if !isValidUUID(sessionID) {
+		fail("nome de arquivo não é um UUID de sessão válido")
+		return
+	}
+	if project == "" {
+		fail("projeto de destino não informado")
+		return
+	}
+
+	sanitizedProject, err := sanitizeProjectName(project)
+	if err != nil {
+		fail(fmt.Sprintf("nome de projeto inválido: %v", err))
+		return
+	}
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		fail(fmt.Sprintf("erro ao ler arquivo de origem: %v", err))
+		return
+	}
+
+	projectDir := filepath.Join(getClaudeProjectsDir(), sanitizedProject)
+	destPath := filepath.Join(projectDir, sessionID+".jsonl")
+
+	if _, err := os.Stat(destPath); err == nil {
+		fail("sessão já existe no projeto de destino (deduplicada)")
+		return
+	}
+
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		fail(fmt.Sprintf("erro ao criar diretório do projeto: %v", err))
+		return
+	}
+
+	if err := os.WriteFile(destPath, data, 0644); err != nil {
+		fail(fmt.Sprintf("erro ao gravar sessão importada: %v", err))
+		return
+	}
+
+	os.Remove(sourcePath)
+	invalidateSessionCache(sanitizedProject)
+
+	log.Printf("📥 Sessão enrollada: %s → projeto %s", sessionID, sanitizedProject)
+	recordEnroll(sourcePath, enrollRecord{
+		File: fileName, Project: sanitizedProject, SessionID: sessionID,
+		Status: "imported", EnrolledAt: time.Now(),
+	})
+}
+
+// handleEnrollUpload recebe um .jsonl via multipart/form-data (campos
+// "project" e "file") e o importa de forma síncrona, pelo mesmo caminho que
+// o watcher usa para arquivos soltos no diretório - o contraponto de
+// importação dos endpoints de delete/fork já existentes.
+func handleEnrollUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, fmt.Sprintf("multipart inválido: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	project := r.FormValue("project")
+	if project == "" {
+		http.Error(w, "campo 'project' é obrigatório", http.StatusBadRequest)
+		return
+	}
+
+	sanitizedProject, err := sanitizeProjectName(project)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("nome de projeto inválido: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeScope(w, r, projectScope("write", sanitizedProject)) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("campo 'file' é obrigatório: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	stagingDir := filepath.Join(enrollDir(), sanitizedProject)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		http.Error(w, fmt.Sprintf("erro ao preparar diretório de staging: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	stagingPath := filepath.Join(stagingDir, filepath.Base(header.Filename))
+	dest, err := os.Create(stagingPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("erro ao gravar upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.Copy(dest, file); err != nil {
+		dest.Close()
+		http.Error(w, fmt.Sprintf("erro ao gravar upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	dest.Close()
+
+	importEnrolledSession(stagingPath, sanitizedProject)
+
+	enrollRegistry.RLock()
+	rec, ok := enrollRegistry.records[stagingPath]
+	enrollRegistry.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "reason": "estado de importação não encontrado"})
+		return
+	}
+	json.NewEncoder(w).Encode(rec)
+}
+
+// handleEnrollStatus lista os registros de enrollment, opcionalmente
+// filtrados por ?status=pending|imported|failed.
+//
+// Cada registro só é devolvido se o chamador tiver project:read para o
+// projeto dele - do contrário qualquer usuário autenticado, com qualquer
+// escopo, enxergaria nomes de arquivo e session_id de importações de todos
+// os outros tenants.
+func handleEnrollStatus(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("status")
+
+	enrollRegistry.RLock()
+	records := make([]*enrollRecord, 0, len(enrollRegistry.records))
+	for _, rec := range enrollRegistry.records {
+		if filter != "" && rec.Status != filter {
+			continue
+		}
+		if !requestHasScope(r, projectScope("read", rec.Project)) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	enrollRegistry.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"imports": records})
+}
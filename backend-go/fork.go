@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// forkProvenance registra de onde uma sessão bifurcada veio. É gravado ao
+// lado do .jsonl do fork, em <session>.fork.json, em vez de como um registro
+// líder dentro do próprio JSONL - assim o arquivo de sessão continua sendo
+// só mensagens, e leitores que não conhecem fork não precisam filtrar nada.
+type forkProvenance struct {
+	ParentSession string    `json:"parent_session"`
+	ParentAnchor  int       `json:"parent_anchor"` // linha 1-based até onde o fork copiou
+	ForkedAt      time.Time `json:"forked_at"`
+}
+
+func forkProvenancePath(sessionFile string) string {
+	return strings.TrimSuffix(sessionFile, ".jsonl") + ".fork.json"
+}
+
+func writeForkProvenance(sessionFile string, prov forkProvenance) error {
+	data, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(forkProvenancePath(sessionFile), data, 0644)
+}
+
+// readForkProvenance retorna ok=false se a sessão não é um fork (sem
+// sidecar), o caso comum para a maioria das sessões.
+func readForkProvenance(sessionFile string) (prov forkProvenance, ok bool) {
+	data, err := os.ReadFile(forkProvenancePath(sessionFile))
+	if err != nil {
+		return prov, false
+	}
+	if err := json.Unmarshal(data, &prov); err != nil {
+		return prov, false
+	}
+	return prov, true
+}
+
+// jsonlMessageID extrai message.id de uma linha de sessão, no formato que o
+// Claude CLI grava ({"type": "...", "message": {"id": "...", ...}, ...}).
+// Linhas que não seguem esse formato (ou a linha de provenance, se algum dia
+// existir) simplesmente não batem com nenhum fork_at_message_id.
+func jsonlMessageID(line string) string {
+	var parsed struct {
+		Message struct {
+			ID string `json:"id"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(line), &parsed); err != nil {
+		return ""
+	}
+	return parsed.Message.ID
+}
+
+// locateForkAnchor decide até qual linha (1-based, inclusive) o fork deve
+// copiar. Sem fork_at_message_id nem fork_at_line, copia o arquivo inteiro -
+// o comportamento anterior ao chunk1-5.
+func locateForkAnchor(lines []string, forkAtMessageID *string, forkAtLine *int) (int, error) {
+	if forkAtMessageID != nil && *forkAtMessageID != "" {
+		for i, line := range lines {
+			if jsonlMessageID(line) == *forkAtMessageID {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("fork_at_message_id não encontrado na sessão fonte")
+	}
+
+	if forkAtLine != nil {
+		if *forkAtLine < 1 || *forkAtLine > len(lines) {
+			return 0, fmt.Errorf("fork_at_line fora do intervalo (1-%d)", len(lines))
+		}
+		return *forkAtLine, nil
+	}
+
+	return len(lines), nil
+}
+
+// writeForkFile lê o .jsonl fonte, copia as linhas [0, anchor) para o arquivo
+// de fork e devolve o anchor efetivamente usado (1-based). O anchor é
+// localizado sobre o conteúdo decifrado (decryptSessionLineTransparent, a
+// mesma usada pelos demais leitores de sessão desde chunk0-5) porque
+// fork_at_message_id casa contra message.id em texto puro - mas as linhas
+// gravadas no arquivo de fork são as originais (lines, não decryptedLines),
+// preservando o estado cifrado/não-cifrado de cada linha copiada.
+func writeForkFile(sourcePath, forkPath string, forkAtMessageID *string, forkAtLine *int) (anchor int, err error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	decryptedLines := make([]string, len(lines))
+	for i, line := range lines {
+		decryptedLines[i] = decryptSessionLineTransparent(line)
+	}
+
+	anchor, err = locateForkAnchor(decryptedLines, forkAtMessageID, forkAtLine)
+	if err != nil {
+		return 0, err
+	}
+
+	var out strings.Builder
+	for _, line := range lines[:anchor] {
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+
+	if err := os.WriteFile(forkPath, []byte(out.String()), 0644); err != nil {
+		return 0, err
+	}
+
+	return anchor, nil
+}
+
+// lineageNode descreve uma sessão na árvore de forks retornada por
+// GET /api/sessions/{id}/lineage.
+type lineageNode struct {
+	SessionID     string    `json:"session_id"`
+	Project       string    `json:"project"`
+	ParentSession string    `json:"parent_session,omitempty"`
+	ParentAnchor  int       `json:"parent_anchor,omitempty"`
+	ForkedAt      time.Time `json:"forked_at,omitempty"`
+}
+
+// findSessionFile localiza um .jsonl de sessão por ID em qualquer projeto,
+// já que GET /api/sessions/{id}/lineage não recebe o nome do projeto.
+func findSessionFile(sessionID string) (project string, sessionFile string, ok bool) {
+	root := getClaudeProjectsDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", "", false
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		candidate := filepath.Join(root, entry.Name(), sessionID+".jsonl")
+		if _, err := os.Stat(candidate); err == nil {
+			return entry.Name(), candidate, true
+		}
+	}
+
+	return "", "", false
+}
+
+// allForkSidecars varre todos os projetos e devolve, por session_id, o nó de
+// lineage correspondente - usado para montar a cadeia de ancestrais e a
+// lista de descendentes sem precisar repetir a varredura por nível.
+func allForkSidecars() map[string]lineageNode {
+	nodes := make(map[string]lineageNode)
+
+	root := getClaudeProjectsDir()
+	projectDirs, err := os.ReadDir(root)
+	if err != nil {
+		return nodes
+	}
+
+	for _, projectEntry := range projectDirs {
+		if !projectEntry.IsDir() {
+			continue
+		}
+		projectDir := filepath.Join(root, projectEntry.Name())
+
+		sessionFiles, err := os.ReadDir(projectDir)
+		if err != nil {
+			continue
+		}
+
+		for _, sessionEntry := range sessionFiles {
+			if sessionEntry.IsDir() || filepath.Ext(sessionEntry.Name()) != ".jsonl" {
+				continue
+			}
+			sessionID := strings.TrimSuffix(sessionEntry.Name(), ".jsonl")
+			sessionFile := filepath.Join(projectDir, sessionEntry.Name())
+
+			node := lineageNode{SessionID: sessionID, Project: projectEntry.Name()}
+			if prov, ok := readForkProvenance(sessionFile); ok {
+				node.ParentSession = prov.ParentSession
+				node.ParentAnchor = prov.ParentAnchor
+				node.ForkedAt = prov.ForkedAt
+			}
+			nodes[sessionID] = node
+		}
+	}
+
+	return nodes
+}
+
+// handleSessionLineage monta a árvore de forks de uma sessão: a cadeia de
+// ancestrais (subindo via parent_session) e os descendentes diretos e
+// indiretos (descobertos varrendo todos os sidecars .fork.json).
+func handleSessionLineage(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+
+	project, _, ok := findSessionFile(sessionID)
+	if !ok {
+		http.Error(w, "Sessão não encontrada", http.StatusNotFound)
+		return
+	}
+
+	nodes := allForkSidecars()
+	self := lineageNode{SessionID: sessionID, Project: project}
+	if n, ok := nodes[sessionID]; ok {
+		self = n
+	}
+
+	var ancestors []lineageNode
+	cursor := self
+	seen := map[string]bool{sessionID: true}
+	for cursor.ParentSession != "" && !seen[cursor.ParentSession] {
+		parent, ok := nodes[cursor.ParentSession]
+		if !ok {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		seen[parent.SessionID] = true
+		cursor = parent
+	}
+
+	childrenOf := make(map[string][]lineageNode)
+	for _, n := range nodes {
+		if n.ParentSession != "" {
+			childrenOf[n.ParentSession] = append(childrenOf[n.ParentSession], n)
+		}
+	}
+
+	var descendants []lineageNode
+	queue := childrenOf[sessionID]
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		descendants = append(descendants, next)
+		queue = append(queue, childrenOf[next.SessionID]...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session":     self,
+		"ancestors":   ancestors,
+		"descendants": descendants,
+	})
+}
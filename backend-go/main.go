@@ -2,12 +2,11 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -31,6 +30,10 @@ type SSEMessage struct {
 	Type      string  `json:"type"`
 	Content   string  `json:"content"`
 	SessionID *string `json:"session_id,omitempty"`
+	Stage     string  `json:"stage,omitempty"`
+	TokensIn  int     `json:"tokens_in,omitempty"`
+	TokensOut int     `json:"tokens_out,omitempty"`
+	ElapsedMs int64   `json:"elapsed_ms,omitempty"`
 }
 
 // SessionInfo representa informações sobre uma sessão
@@ -55,23 +58,33 @@ var sessionCache = struct {
 
 // messageQueue representa uma fila de mensagens para uma sessão
 type messageQueue struct {
+	sessionID  string
 	messages   []queuedMessage
 	processing bool
 	mu         sync.Mutex
 }
 
-// queuedMessage representa uma mensagem enfileirada com seu contexto
+// queuedMessage representa uma mensagem enfileirada com seu contexto. id
+// correlaciona com o storedMessage persistido em queue_store.go.
 type queuedMessage struct {
-	message  ChatRequest
-	response chan<- sseEvent
-	ctx      context.Context
+	id         string
+	message    ChatRequest
+	response   chan<- sseEvent
+	ctx        context.Context
+	enqueuedAt time.Time
 }
 
 // sseEvent representa um evento SSE a ser enviado ao cliente
 type sseEvent struct {
-	eventType string // "text", "error", "done"
+	eventType string // "text", "error", "done", "progress", "cancelled"
 	content   string
 	sessionID *string
+
+	// Campos de "progress" (ver progress.go); vazios/zero para os demais tipos.
+	stage     string
+	tokensIn  int
+	tokensOut int
+	elapsedMs int64
 }
 
 // sessionQueues mantém filas de mensagens por session_id
@@ -90,6 +103,7 @@ func getOrCreateQueue(sessionID string) *messageQueue {
 	queue, exists := sessionQueues.queues[sessionID]
 	if !exists {
 		queue = &messageQueue{
+			sessionID:  sessionID,
 			messages:   make([]queuedMessage, 0),
 			processing: false,
 		}
@@ -104,6 +118,7 @@ func (q *messageQueue) enqueue(msg queuedMessage) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	q.messages = append(q.messages, msg)
+	sessionQueueDepth.WithLabelValues(q.sessionID).Set(float64(len(q.messages)))
 	log.Printf("➕ Mensagem enfileirada (total: %d)", len(q.messages))
 }
 
@@ -118,6 +133,7 @@ func (q *messageQueue) dequeue() (queuedMessage, bool) {
 
 	msg := q.messages[0]
 	q.messages = q.messages[1:]
+	sessionQueueDepth.WithLabelValues(q.sessionID).Set(float64(len(q.messages)))
 	log.Printf("➖ Mensagem desenfileirada (restantes: %d)", len(q.messages))
 	return msg, true
 }
@@ -136,7 +152,9 @@ func (q *messageQueue) setProcessing(processing bool) {
 	q.processing = processing
 }
 
-// processQueue processa mensagens da fila sequencialmente
+// processQueue processa mensagens da fila sequencialmente. É agnóstico ao
+// backend: delega a execução do turno para streamWithFailover, que escolhe
+// entre os ChatBackends configurados via CHAT_BACKEND (ver backend.go).
 func processQueue(sessionID string, projectDir string, initialSessionExists bool) {
 	queue := getOrCreateQueue(sessionID)
 
@@ -160,108 +178,68 @@ func processQueue(sessionID string, projectDir string, initialSessionExists bool
 			log.Printf("📝 Sessão não existe, criando nova: %s", sessionFile)
 		}
 
-		executeClaudeCLI(msg.ctx, msg.message, sessionID, projectDir, sessionExists, msg.response)
-	}
-}
-
-// executeClaudeCLI agora faz proxy para o backend Python que usa o SDK oficial
-func executeClaudeCLI(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool, eventChan chan<- sseEvent) {
-	defer close(eventChan)
-
-	log.Printf("🔄 Proxy para Python SDK - Sessão: %s", sessionID)
-
-	// Extrair project_id do projectDir
-	// projectDir = /Users/2a/.claude/projetos/teste-memoria
-	// project_id deve ser apenas o nome final: teste-memoria
-	projectID := filepath.Base(projectDir)
-
-	log.Printf("📦 project_id extraído: %s (de %s)", projectID, projectDir)
-
-	// Preparar payload para o Python backend
-	payload := map[string]interface{}{
-		"message":    req.Message,
-		"session_id": sessionID,
-		"project_id": projectID,
-		"cwd":        projectDir, // Caminho completo do projeto para o SDK usar como working directory
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro ao criar payload: %v", err)}
-		return
-	}
-
-	// Fazer requisição HTTP para o Python backend
-	pythonURL := "http://localhost:8080/api/chat"
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", pythonURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro ao criar request: %v", err)}
-		return
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{} // Sem timeout - permite Agent SDK executar pre-flight checks
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro ao conectar com Python: %v", err)}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		eventChan <- sseEvent{eventType: "error", content: fmt.Sprintf("Erro HTTP %d: %s", resp.StatusCode, string(body))}
-		return
-	}
+		markMessageRunning(msg.id)
+		if !msg.enqueuedAt.IsZero() {
+			setAccessLogQueueWait(msg.ctx, time.Since(msg.enqueuedAt))
+		}
 
-	// Ler stream SSE do Python e repassar para o canal
-	reader := bufio.NewReader(resp.Body)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("Erro ao ler stream: %v", err)
+		// runCtx é derivado de msg.ctx só para que DELETE /api/chat/{session_id}
+		// consiga cancelar este turno especificamente mesmo quando msg.ctx em si
+		// (ex: context.Background() do handler SSE) não é cancelável.
+		runCtx, cancelRun := context.WithCancel(msg.ctx)
+		registerChatCancel(sessionID, msg.id, filepath.Base(projectDir), cancelRun)
+
+		tracker := newProgressTracker()
+		tokensIn := approxTokenCount(msg.message.Message)
+		start := time.Now()
+		progressDone := make(chan struct{})
+		// progressStopped só fecha depois que emitProgress de fato retornou -
+		// sem isso, close(progressDone) apenas pede para a goroutine parar, mas
+		// não impede uma tick já em andamento de tentar "msg.response <- evt"
+		// depois que close(msg.response) abaixo já tiver rodado (o caso
+		// "default" do select em emitProgress não protege contra canal
+		// fechado: um envio para canal fechado sempre entra pronto e entra em
+		// panic, derrubando o processo inteiro).
+		progressStopped := make(chan struct{})
+		go func() {
+			emitProgress(sessionID, msg, tracker, tokensIn, start, progressDone)
+			close(progressStopped)
+		}()
+
+		failed := false
+		events := streamWithFailover(runCtx, msg.message, sessionID, projectDir, sessionExists)
+		for event := range events {
+			if event.eventType == "error" {
+				failed = true
 			}
-			break
+			tracker.observeEvent(event)
+			persistAndPublishEvent(sessionID, event)
+			msg.response <- event
 		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		close(progressDone)
+		<-progressStopped
+		unregisterChatCancel(sessionID, msg.id)
+
+		cancelled := runCtx.Err() == context.Canceled
+		if cancelled {
+			cancelEvt := sseEvent{eventType: "cancelled", sessionID: &sessionID}
+			persistAndPublishEvent(sessionID, cancelEvt)
+			msg.response <- cancelEvt
 		}
-
-		// Parsear linha SSE
-		if strings.HasPrefix(line, "data: ") {
-			dataStr := strings.TrimPrefix(line, "data: ")
-
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
-				log.Printf("Erro ao parsear JSON: %v", err)
-				continue
-			}
-
-			// Converter para sseEvent
-			eventType, _ := data["type"].(string)
-
-			if eventType == "text" || eventType == "content" {
-				if content, ok := data["content"].(string); ok {
-					eventChan <- sseEvent{eventType: "text", content: content}
-				} else if text, ok := data["text"].(string); ok {
-					eventChan <- sseEvent{eventType: "text", content: text}
-				}
-			} else if eventType == "done" || eventType == "session_created" {
-				eventChan <- sseEvent{eventType: "done", sessionID: &sessionID}
-			} else if eventType == "error" {
-				if errorMsg, ok := data["error"].(string); ok {
-					eventChan <- sseEvent{eventType: "error", content: errorMsg}
-				}
-			}
+		cancelRun()
+
+		close(msg.response)
+		sessionQueueProcessingSeconds.WithLabelValues(sessionID).Observe(time.Since(start).Seconds())
+
+		switch {
+		case cancelled:
+			markMessageCancelled(msg.id)
+		case failed:
+			markMessageFailed(msg.id)
+		default:
+			markMessageDone(msg.id)
 		}
 	}
-
-	log.Printf("✅ Proxy Python finalizado para sessão: %s", sessionID)
 }
 
 // appendToSessionFile removida - persistência agora é feita pelo Python SDK
@@ -274,8 +252,10 @@ func getCachedSessions(projectName string) ([]SessionInfo, bool) {
 	entry, exists := sessionCache.data[projectName]
 	// Cache válido por 5 minutos
 	if !exists || time.Since(entry.timestamp) > 5*time.Minute {
+		sessionCacheMissesTotal.Inc()
 		return nil, false
 	}
+	sessionCacheHitsTotal.Inc()
 	return entry.sessions, true
 }
 
@@ -327,6 +307,15 @@ func getClaudeProjetosDir() string {
 	return filepath.Join(getClaudeBaseDir(), "projetos")
 }
 
+// sessionIDPattern valida o formato de um UUID: 8-4-4-4-12 caracteres
+// hexadecimais (o dígito de versão não é verificado).
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// isValidUUID verifica se uma string é um UUID válido (nome de arquivo de sessão)
+func isValidUUID(s string) bool {
+	return sessionIDPattern.MatchString(s)
+}
+
 // validatePath verifica se o path está dentro do base path permitido
 func validatePath(path, basePath string) error {
 	// Limpar o path
@@ -450,6 +439,7 @@ func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		limiter := getRateLimiter(ip)
 
 		if !limiter.Allow() {
+			rateLimitRejectionsTotal.WithLabelValues(ipWithoutPort(ip)).Inc()
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 			log.Printf("⚠️  Rate limit exceeded para IP: %s", ip)
 			return
@@ -459,43 +449,135 @@ func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// authMiddleware verifica API key se configurada
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		apiKey := os.Getenv("API_KEY")
+// ipWithoutPort remove a porta efêmera de r.RemoteAddr antes de usá-lo como
+// label do Prometheus - caso contrário, cada conexão TCP (mesmo do mesmo
+// cliente) vira uma série distinta.
+func ipWithoutPort(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
 
-		// Se API_KEY não configurada, pular autenticação (dev mode)
-		if apiKey == "" {
-			next(w, r)
-			return
+// allowedOrigins é a lista explícita de origens permitidas, compartilhada
+// pelo corsMiddleware e pelo upgrade de WebSocket (que não passa pelo pacote cors).
+var allowedOrigins = map[string]bool{
+	"http://localhost:3000": true,
+	"http://localhost:3001": true,
+	"http://localhost:3002": true,
+	"http://localhost:3003": true,
+}
+
+func init() {
+	// Quando o backend é exposto diretamente via TLS (ver tls.go), o frontend
+	// passa a acessar via https:// - garantir que essas origens também sejam aceitas.
+	for _, origin := range withHTTPSVariants(corsOrigins()) {
+		allowedOrigins[origin] = true
+	}
+}
+
+// corsOrigins é a lista base de origens HTTP permitidas, usada tanto pelo
+// corsMiddleware manual quanto pelas AllowedOrigins do pacote rs/cors.
+func corsOrigins() []string {
+	return []string{
+		"http://localhost:3000",
+		"http://localhost:3001",
+		"http://localhost:3002",
+		"http://localhost:3003",
+	}
+}
+
+// resolveChatSession decide sessionID, diretório do projeto e se a sessão já
+// existe em disco, a partir de um ChatRequest. Extraído do handler de /api/chat
+// para ser reutilizado pelo endpoint WebSocket equivalente.
+func resolveChatSession(req ChatRequest) (sessionID string, projectDir string, sessionExists bool) {
+	if req.SessionID != nil && *req.SessionID != "" {
+		sessionID = *req.SessionID
+
+		if req.ProjectName != nil && *req.ProjectName != "" {
+			// Primeiro, tentar encontrar a sessão existente em /projects/ (onde o SDK salva)
+			projectsBase := getClaudeProjectsDir()
+			existingSessionPath := filepath.Join(projectsBase, *req.ProjectName, sessionID+".jsonl")
+
+			log.Printf("🔍 Procurando sessão em: %s", existingSessionPath)
+
+			if _, err := os.Stat(existingSessionPath); err == nil {
+				// Sessão existe em /projects/ - usar esse diretório
+				projectDir = filepath.Join(projectsBase, *req.ProjectName)
+				sessionExists = true
+				log.Printf("✅ Sessão existente encontrada em: %s", existingSessionPath)
+			} else {
+				log.Printf("❌ Sessão não encontrada (erro: %v), criando nova", err)
+				// Sessão não existe - criar novo projeto em /projetos/
+				claudeBase := getClaudeProjetosDir()
+				projectDir = filepath.Join(claudeBase, *req.ProjectName)
+
+				if err := os.MkdirAll(projectDir, 0755); err != nil {
+					log.Printf("Erro ao criar diretório do projeto: %v", err)
+				}
+				sessionExists = false
+				log.Printf("📁 Novo projeto criado em: %s", projectDir)
+			}
+		} else {
+			projectsBase := getClaudeProjectsDir()
+			dirs, _ := os.ReadDir(projectsBase)
+
+			for _, dir := range dirs {
+				if dir.IsDir() {
+					projPath := filepath.Join(projectsBase, dir.Name())
+					sessionFile := filepath.Join(projPath, sessionID+".jsonl")
+					if _, err := os.Stat(sessionFile); err == nil {
+						projectDir = projPath
+						sessionExists = true
+						log.Printf("Sessão encontrada em: %s", projPath)
+						break
+					}
+				}
+			}
+
+			if projectDir == "" {
+				projectDir = getClaudeBaseDir()
+				sessionExists = false
+			}
 		}
+	} else {
+		projectDir = getClaudeBaseDir()
+		entries, _ := os.ReadDir(projectDir)
 
-		// Verificar header X-API-Key
-		providedKey := r.Header.Get("X-API-Key")
-		if providedKey != apiKey {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			log.Printf("⚠️  Acesso não autorizado: %s", r.RemoteAddr)
-			return
+		for _, entry := range entries {
+			if !entry.IsDir() && filepath.Ext(entry.Name()) == ".jsonl" {
+				sessionID = strings.TrimSuffix(entry.Name(), ".jsonl")
+				sessionExists = true
+				break
+			}
 		}
 
-		next(w, r)
+		if !sessionExists {
+			sessionID = uuid.New().String()
+		}
 	}
+
+	return sessionID, projectDir, sessionExists
 }
 
 func main() {
 	log.Println("🚀 Backend Go iniciando na porta 8000...")
 	log.Println("✅ Usando CLI do Claude (sem API key necessária)")
 
-	mux := http.NewServeMux()
+	startFilesystemWatcher()
+	initScopePolicy()
 
-	// Middleware CORS - Lista explícita de origens permitidas
-	allowedOrigins := map[string]bool{
-		"http://localhost:3000": true,
-		"http://localhost:3001": true,
-		"http://localhost:3002": true,
-		"http://localhost:3003": true,
+	initQueueStore()
+	if persistentQueueStore != nil {
+		defer persistentQueueStore.Close()
+		requeueOrphanedJobs()
 	}
 
+	initEnroller()
+
+	mux := http.NewServeMux()
+
 	corsMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
@@ -532,6 +614,12 @@ func main() {
 		}
 	}))
 
+	// Autenticação stateless via cookie assinado/cifrado (ver auth.go)
+	mux.HandleFunc("POST /api/auth/login", corsMiddleware(handleAuthLogin))
+	mux.HandleFunc("POST /api/auth/logout", corsMiddleware(handleAuthLogout))
+	mux.HandleFunc("GET /api/auth/whoami", authMiddleware(corsMiddleware(handleAuthWhoami)))
+	mux.HandleFunc("GET /api/auth/scopes", authMiddleware(corsMiddleware(handleAuthScopes)))
+
 	// Endpoint para monitorar sessão em tempo real (raw JSONL)
 	mux.HandleFunc("GET /api/live-session", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -584,7 +672,7 @@ func main() {
 		var lines []string
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
+			lines = append(lines, decryptSessionLineTransparent(scanner.Text()))
 		}
 
 		// Pegar apenas as últimas 20 linhas para preview
@@ -783,6 +871,9 @@ func main() {
 		})
 	})
 
+	// Árvore de forks de uma sessão (ancestrais e descendentes, ver fork.go)
+	mux.HandleFunc("GET /api/sessions/{id}/lineage", corsMiddleware(handleSessionLineage))
+
 	// Obter conteúdo de uma sessão específica
 	mux.HandleFunc("GET /api/projects/{projectName}/sessions/{sessionID}", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -814,7 +905,7 @@ func main() {
 		var lines []string
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
+			lines = append(lines, decryptSessionLineTransparent(scanner.Text()))
 		}
 
 		if err := scanner.Err(); err != nil {
@@ -830,12 +921,16 @@ func main() {
 	}))
 
 	// Deletar uma sessão específica
-	mux.HandleFunc("DELETE /api/projects/{projectName}/sessions/{sessionID}", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("DELETE /api/projects/{projectName}/sessions/{sessionID}", authMiddleware(csrfMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		projectName := r.PathValue("projectName")
 		sessionID := r.PathValue("sessionID")
 
+		if !authorizeScope(w, r, projectScope("write", projectName)) {
+			return
+		}
+
 		sessionFile := filepath.Join(getClaudeProjectsDir(), projectName, sessionID+".jsonl")
 
 		log.Printf("🗑️  Tentando deletar sessão: %s", sessionFile)
@@ -877,12 +972,16 @@ func main() {
 			"success": true,
 			"message": "Sessão deletada com sucesso",
 		})
-	}))
+	})))) // authMiddleware + csrfMiddleware + corsMiddleware
 
 	// Limpar histórico da home
-	mux.HandleFunc("POST /api/clear-history", authMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /api/clear-history", authMiddleware(csrfMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		if !authorizeScope(w, r, "home:clear") {
+			return
+		}
+
 		// Deletar arquivo da sessão home
 		homeSessionFile := filepath.Join(getClaudeProjectsDir(), "-Users-2a--claude-projetos-home", "00000000-0000-0000-0000-000000000001.jsonl")
 
@@ -909,23 +1008,58 @@ func main() {
 			"success": true,
 			"message": "Histórico limpo com sucesso",
 		})
-	})))
+	}))))
 
-	// isValidUUID verifica se uma string é um UUID válido
-	isValidUUID := func(s string) bool {
-		// Regex para UUID v4: 8-4-4-4-12 caracteres hexadecimais
-		matched, _ := regexp.MatchString(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`, s)
-		return matched
-	}
+
+	// Migrar sessões em texto puro de um projeto para o formato cifrado
+	mux.HandleFunc("POST /api/projects/{projectName}/encrypt", authMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		projectName := r.PathValue("projectName")
+		sanitized, err := sanitizeProjectName(projectName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid project name: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		key, ok := sessionEncryptionKey()
+		if !ok {
+			http.Error(w, "SESSION_ENCRYPTION_KEY não configurada", http.StatusPreconditionFailed)
+			return
+		}
+
+		projectDir := filepath.Join(getClaudeProjectsDir(), sanitized)
+		if err := validatePath(projectDir, getClaudeProjectsDir()); err != nil {
+			http.Error(w, fmt.Sprintf("invalid path: %v", err), http.StatusForbidden)
+			return
+		}
+
+		migrated, err := encryptProjectSessions(projectDir, key)
+		if err != nil {
+			log.Printf("❌ Erro ao migrar sessões de %s: %v", sanitized, err)
+			http.Error(w, fmt.Sprintf("erro ao migrar sessões: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("🔒 %d sessões cifradas para o projeto: %s", migrated, sanitized)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"migrated": migrated,
+			"project":  sanitized,
+		})
+	})))
 
 	// Fork session - Cria uma ramificação de uma sessão existente
-	mux.HandleFunc("POST /api/fork-session", authMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /api/fork-session", authMiddleware(csrfMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		var req struct {
-			SourceSession string `json:"source_session"`
-			ForkSession   string `json:"fork_session"`
-			ProjectName   string `json:"project_name"`
+			SourceSession   string  `json:"source_session"`
+			ForkSession     string  `json:"fork_session"`
+			ProjectName     string  `json:"project_name"`
+			ForkAtMessageID *string `json:"fork_at_message_id"`
+			ForkAtLine      *int    `json:"fork_at_line"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -945,6 +1079,13 @@ func main() {
 			return
 		}
 
+		if !authorizeScope(w, r, "session:fork") {
+			return
+		}
+		if !authorizeScope(w, r, projectScope("write", req.ProjectName)) {
+			return
+		}
+
 		// Construir caminhos
 		projectDir := filepath.Join(getClaudeProjectsDir(), req.ProjectName)
 		sourcePath := filepath.Join(projectDir, req.SourceSession+".jsonl")
@@ -962,14 +1103,6 @@ func main() {
 			return
 		}
 
-		// Ler arquivo fonte
-		data, err := os.ReadFile(sourcePath)
-		if err != nil {
-			log.Printf("Erro ao ler sessão fonte: %v", err)
-			http.Error(w, "Erro ao ler sessão fonte", http.StatusInternalServerError)
-			return
-		}
-
 		// Criar diretório do projeto se não existir
 		if err := os.MkdirAll(projectDir, 0755); err != nil {
 			log.Printf("Erro ao criar diretório: %v", err)
@@ -977,29 +1110,37 @@ func main() {
 			return
 		}
 
-		// Escrever arquivo fork
-		if err := os.WriteFile(forkPath, data, 0644); err != nil {
+		// Copy-on-write: copia só até o anchor (mensagem ou linha solicitada),
+		// em vez do arquivo inteiro - ver fork.go
+		anchor, err := writeForkFile(sourcePath, forkPath, req.ForkAtMessageID, req.ForkAtLine)
+		if err != nil {
 			log.Printf("Erro ao criar fork: %v", err)
-			http.Error(w, "Erro ao criar fork", http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Erro ao criar fork: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		log.Printf("🔀 Fork criado: %s → %s", req.SourceSession, req.ForkSession)
+		prov := forkProvenance{ParentSession: req.SourceSession, ParentAnchor: anchor, ForkedAt: time.Now()}
+		if err := writeForkProvenance(forkPath, prov); err != nil {
+			log.Printf("⚠️  Erro ao gravar proveniência do fork: %v", err)
+		}
+
+		log.Printf("🔀 Fork criado: %s → %s (anchor=%d)", req.SourceSession, req.ForkSession, anchor)
 
 		// Invalidar cache do projeto
 		invalidateSessionCache(req.ProjectName)
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":         true,
-			"fork_session_id": req.ForkSession,
-			"source_session_id": req.SourceSession,
-			"project_name":    req.ProjectName,
-			"fork_path":       forkPath,
+			"success":            true,
+			"fork_session_id":    req.ForkSession,
+			"source_session_id":  req.SourceSession,
+			"project_name":       req.ProjectName,
+			"fork_path":          forkPath,
+			"fork_anchor":        anchor,
 		})
-	})))
+	}))))
 
-	// Chat endpoint com streaming SSE via CLI (com rate limiting e autenticação)
-	mux.HandleFunc("POST /api/chat", authMiddleware(rateLimitMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	// Chat endpoint com streaming SSE via CLI (com rate limiting, autenticação e CSRF)
+	mux.HandleFunc("POST /api/chat", authMiddleware(csrfMiddleware(rateLimitMiddleware(corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		var req ChatRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -1015,6 +1156,17 @@ func main() {
 
 		req.Message = sanitized
 
+		chatProjectName := "home"
+		if req.ProjectName != nil && *req.ProjectName != "" {
+			chatProjectName = *req.ProjectName
+		}
+		if !authorizeScope(w, r, "chat:send") {
+			return
+		}
+		if !authorizeScope(w, r, projectScope("write", chatProjectName)) {
+			return
+		}
+
 		// Configurar SSE
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
@@ -1027,103 +1179,38 @@ func main() {
 			return
 		}
 
-		ctx := context.Background()
-
-		var sessionID string
-		var sessionExists bool
-		var projectDir string
-
-		if req.SessionID != nil && *req.SessionID != "" {
-			sessionID = *req.SessionID
-
-			if req.ProjectName != nil && *req.ProjectName != "" {
-				// Primeiro, tentar encontrar a sessão existente em /projects/ (onde o SDK salva)
-				projectsBase := getClaudeProjectsDir()
-				existingSessionPath := filepath.Join(projectsBase, *req.ProjectName, sessionID+".jsonl")
-
-				log.Printf("🔍 Procurando sessão em: %s", existingSessionPath)
-
-				if _, err := os.Stat(existingSessionPath); err == nil {
-					// Sessão existe em /projects/ - usar esse diretório
-					projectDir = filepath.Join(projectsBase, *req.ProjectName)
-					sessionExists = true
-					log.Printf("✅ Sessão existente encontrada em: %s", existingSessionPath)
-				} else {
-					log.Printf("❌ Sessão não encontrada (erro: %v), criando nova", err)
-					// Sessão não existe - criar novo projeto em /projetos/
-					claudeBase := getClaudeProjetosDir()
-					projectDir = filepath.Join(claudeBase, *req.ProjectName)
-
-					if err := os.MkdirAll(projectDir, 0755); err != nil {
-						msg := SSEMessage{Type: "error", Content: fmt.Sprintf("Erro ao criar diretório do projeto: %v", err)}
-						data, _ := json.Marshal(msg)
-						fmt.Fprintf(w, "data: %s\n\n", data)
-						flusher.Flush()
-						return
-					}
-					sessionExists = false
-					log.Printf("📁 Novo projeto criado em: %s", projectDir)
-				}
-			} else {
-				projectsBase := getClaudeProjectsDir()
-				dirs, _ := os.ReadDir(projectsBase)
-
-				for _, dir := range dirs {
-					if dir.IsDir() {
-						projPath := filepath.Join(projectsBase, dir.Name())
-						sessionFile := filepath.Join(projPath, sessionID+".jsonl")
-						if _, err := os.Stat(sessionFile); err == nil {
-							projectDir = projPath
-							sessionExists = true
-							log.Printf("Sessão encontrada em: %s", projPath)
-							break
-						}
-					}
-				}
-
-				if projectDir == "" {
-					projectDir = getClaudeBaseDir()
-					sessionExists = false
-				}
-			}
-		} else {
-			projectDir = getClaudeBaseDir()
-			entries, _ := os.ReadDir(projectDir)
-
-			for _, entry := range entries {
-				if !entry.IsDir() && filepath.Ext(entry.Name()) == ".jsonl" {
-					sessionID = strings.TrimSuffix(entry.Name(), ".jsonl")
-					sessionExists = true
-					break
-				}
-			}
-
-			if !sessionExists {
-				sessionID = uuid.New().String()
-			}
+		// context.Background() (não o context da requisição) pois a fila pode
+		// processar a mensagem após a resposta HTTP ter sido finalizada; o
+		// request-id e os campos de access-log são propagados via Value para
+		// correlação e para que processQueue consiga registrar o tempo de
+		// espera na fila na mesma linha de log desta requisição.
+		ctx := context.WithValue(context.Background(), requestIDContextKey, requestIDFromContext(r.Context()))
+		ctx = context.WithValue(ctx, accessLogFieldsContextKey, accessLogFieldsFromContext(r.Context()))
+
+		sessionID, projectDir, sessionExists := resolveChatSession(req)
+		setAccessLogSessionID(r.Context(), sessionID)
+		if projectDir == "" {
+			msg := SSEMessage{Type: "error", Content: "Erro ao resolver diretório do projeto"}
+			data, _ := json.Marshal(msg)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+			return
 		}
 
-		// Obter ou criar fila para esta sessão
-		queue := getOrCreateQueue(sessionID)
-
-		// Criar canal para eventos SSE
-		eventChan := make(chan sseEvent, 100)
-
-		// Enfileirar mensagem
-		queue.enqueue(queuedMessage{
-			message:  req,
-			response: eventChan,
-			ctx:      ctx,
-		})
-
-		// Se não está processando, iniciar processamento da fila
-		if !queue.isProcessing() {
-			queue.setProcessing(true)
-			log.Printf("🚀 Iniciando processamento da fila para sessão: %s", sessionID)
-			go processQueue(sessionID, projectDir, sessionExists)
-		} else {
-			log.Printf("⏳ Sessão %s já está processando, mensagem enfileirada", sessionID)
-		}
+		// Persistir e enfileirar mensagem (sobrevive a restart - ver queue_store.go)
+		eventChan := enqueueChatMessage(ctx, req, sessionID, projectDir, sessionExists)
+
+		// Cancela o turno em andamento se o cliente desconectar (ver cancel.go);
+		// handlerDone evita que o watcher cancele um turno novo e não relacionado
+		// da mesma sessão depois que este handler já tiver terminado.
+		handlerDone := make(chan struct{})
+		go func() {
+			select {
+			case <-r.Context().Done():
+				cancelChatSession(sessionID)
+			case <-handlerDone:
+			}
+		}()
 
 		// Ler eventos do canal e enviar via SSE
 		for event := range eventChan {
@@ -1135,12 +1222,24 @@ func main() {
 				msg = SSEMessage{Type: "error", Content: event.content}
 			case "done":
 				msg = SSEMessage{Type: "done", SessionID: event.sessionID}
+			case "progress":
+				msg = SSEMessage{
+					Type:      "progress",
+					SessionID: event.sessionID,
+					Stage:     event.stage,
+					TokensIn:  event.tokensIn,
+					TokensOut: event.tokensOut,
+					ElapsedMs: event.elapsedMs,
+				}
+			case "cancelled":
+				msg = SSEMessage{Type: "cancelled", SessionID: event.sessionID}
 			}
 
 			data, _ := json.Marshal(msg)
 			fmt.Fprintf(w, "data: %s\n\n", data)
 			flusher.Flush()
 		}
+		close(handlerDone)
 
 		// Invalidar cache do projeto após criar/atualizar sessão
 		if req.ProjectName != nil && *req.ProjectName != "" {
@@ -1154,20 +1253,45 @@ func main() {
 				}
 			}
 		}
-	}))))
+	})))))
+
+	// Chat endpoint via WebSocket - multiplexa a mesma fila/infra do SSE, mas
+	// mantém a conexão aberta entre turnos (sem tear-down/reconnect por mensagem)
+	mux.HandleFunc("GET /api/chat/ws", authMiddleware(corsMiddleware(handleChatWebSocket)))
+
+	// Retoma um turno de chat após reconexão, reproduzindo os eventos persistidos
+	// desde from_seq antes de continuar ao vivo (ver queue_store.go)
+	mux.HandleFunc("GET /api/chat/resume", authMiddleware(corsMiddleware(handleChatResume)))
+
+	// Cancela o turno de chat em andamento de uma sessão (ver cancel.go)
+	mux.HandleFunc("DELETE /api/chat/{session_id}", authMiddleware(csrfMiddleware(corsMiddleware(handleChatCancel))))
+
+	// Eventos de filesystem (criação/atualização/remoção de sessão) em push, via SSE
+	mux.HandleFunc("GET /api/events", authMiddleware(corsMiddleware(handleEventsSSE)))
+
+	// Importação em lote de sessões via upload multipart ou diretório observado (ver enroll.go)
+	mux.HandleFunc("POST /api/enroll", authMiddleware(csrfMiddleware(corsMiddleware(handleEnrollUpload))))
+	mux.HandleFunc("GET /api/enroll/status", authMiddleware(corsMiddleware(handleEnrollStatus)))
+
+	// Métricas Prometheus (sem CORS/auth - consumido por scrapers internos)
+	mux.Handle("GET /metrics", metricsHandler())
 
 	// CORS
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:3001", "http://localhost:3002", "http://localhost:3003"},
+	corsHandler := metricsMiddleware(mux, cors.New(cors.Options{
+		AllowedOrigins:   withHTTPSVariants(corsOrigins()),
 		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type"},
 		AllowCredentials: true,
-	}).Handler(mux)
+	}).Handler(mux))
+
+	// Access log estruturado - camada mais externa para cobrir toda requisição,
+	// incluindo o tempo gasto em CORS/métricas
+	handler := accessLogMiddleware(corsHandler.ServeHTTP)
 
-	log.Println("✅ Servidor rodando em http://localhost:8000")
 	log.Println("📊 Health: http://localhost:8000/health")
+	log.Println("📈 Métricas: http://localhost:8000/metrics")
 
-	if err := http.ListenAndServe(":8000", handler); err != nil {
+	if err := serve(handler); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Métricas Prometheus para as subsistemas existentes (fila, cache, rate limit,
+// upstream Python). Antes deste handler o único sinal de operação eram os
+// logs com emoji - isso dá visibilidade sem precisar fazer parsing de log.
+var (
+	sessionQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "session_queue_depth",
+		Help: "Número de mensagens pendentes na fila de uma sessão",
+	}, []string{"session_id"})
+
+	sessionQueueProcessingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "session_queue_processing_seconds",
+		Help:    "Duração de cada turno processado por processQueue",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"session_id"})
+
+	sessionCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "session_cache_hits_total",
+		Help: "Total de acertos no cache de sessões",
+	})
+
+	sessionCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "session_cache_misses_total",
+		Help: "Total de misses no cache de sessões",
+	})
+
+	rateLimitRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Total de requisições rejeitadas por rate limit, por IP",
+	}, []string{"ip"})
+
+	pythonUpstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "python_upstream_errors_total",
+		Help: "Total de respostas não-200 do proxy Python, por código",
+	}, []string{"code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duração das requisições HTTP por rota e status",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+// metricsMiddleware mede duração e status de cada requisição HTTP, usando o
+// padrão de rota registrado no ServeMux (ex: "GET /api/projects/{projectName}"),
+// obtido via mux.Handler(r), como label - nunca o r.URL.Path concreto, que
+// traria um valor de IDs de sessão/projeto distintos e explodiria a
+// cardinalidade da série.
+func metricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusCapturingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(route, r.Method, statusLabel(sw.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusCapturingWriter envolve http.ResponseWriter para capturar o status code escrito.
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusCapturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func statusLabel(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
+
+// metricsHandler expõe as métricas no formato Prometheus.
+func metricsHandler() http.HandlerFunc {
+	h := promhttp.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+	}
+}
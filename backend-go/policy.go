@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// scopePolicyFile é o formato do arquivo YAML de política: mapeia user_id
+// para os escopos concedidos a ele. Os escopos seguem o padrão
+// "<recurso>:<ação>[:<alvo>]", ex.: "project:write:meu-projeto", "chat:send".
+type scopePolicyFile struct {
+	Users map[string]struct {
+		Scopes []string `yaml:"scopes"`
+	} `yaml:"users"`
+}
+
+// scopePolicy é o estado em memória da política carregada, recarregado a
+// quente quando o arquivo muda - assim revogar ou conceder escopo a um
+// usuário não exige reiniciar o backend.
+var scopePolicy = struct {
+	sync.RWMutex
+	users map[string][]string
+}{users: make(map[string][]string)}
+
+func policyFilePath() string {
+	if path := os.Getenv("SCOPE_POLICY_PATH"); path != "" {
+		return path
+	}
+	return filepath.Join(getClaudeBaseDir(), "policy.yaml")
+}
+
+// initScopePolicy carrega a política inicial e inicia o watch do arquivo.
+// Se o arquivo não existir, a política fica vazia (nenhum usuário autorizado)
+// em vez de falhar a inicialização - condizente com o modo dev de auth.go,
+// onde a ausência de configuração não derruba o servidor.
+func initScopePolicy() {
+	loadScopePolicy()
+	watchScopePolicy()
+}
+
+func loadScopePolicy() {
+	path := policyFilePath()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️  Erro ao ler política de escopos (%s): %v", path, err)
+		}
+		return
+	}
+
+	var parsed scopePolicyFile
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		log.Printf("⚠️  Erro ao interpretar política de escopos (%s): %v", path, err)
+		return
+	}
+
+	users := make(map[string][]string, len(parsed.Users))
+	for userID, entry := range parsed.Users {
+		users[userID] = entry.Scopes
+	}
+
+	scopePolicy.Lock()
+	scopePolicy.users = users
+	scopePolicy.Unlock()
+
+	log.Printf("📜 Política de escopos carregada: %d usuário(s) (%s)", len(users), path)
+}
+
+// watchScopePolicy observa o diretório do arquivo de política (não o arquivo
+// em si, já que editores costumam salvar via rename/replace, o que perderia
+// o watch se fosse no arquivo) e recarrega ao detectar qualquer mudança nele.
+func watchScopePolicy() {
+	path := policyFilePath()
+	dir := filepath.Dir(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("⚠️  Não foi possível observar a política de escopos: %v", err)
+		return
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		log.Printf("⚠️  Não foi possível observar %s: %v", dir, err)
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(path) {
+					loadScopePolicy()
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func scopesForUser(userID string) []string {
+	scopePolicy.RLock()
+	defer scopePolicy.RUnlock()
+	return append([]string(nil), scopePolicy.users[userID]...)
+}
+
+func hasScope(scopes []string, required string) bool {
+	for _, s := range scopes {
+		if s == required {
+			return true
+		}
+	}
+	return false
+}
+
+// projectScope monta o escopo de projeto no padrão "project:<ação>:<nome>"
+// usado por project:read:<name> e project:write:<name>.
+func projectScope(action, project string) string {
+	return fmt.Sprintf("project:%s:%s", action, project)
+}
+
+// scopeErrorResponse é o corpo 403 estruturado, para que a UI consiga
+// exibir uma mensagem específica em vez de um "Forbidden" genérico.
+type scopeErrorResponse struct {
+	Error        string `json:"error"`
+	MissingScope string `json:"missing_scope"`
+}
+
+func respondMissingScope(w http.ResponseWriter, scope string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(scopeErrorResponse{Error: "missing_scope", MissingScope: scope})
+}
+
+// requestHasScope confirma que o token da requisição carrega o escopo
+// exigido, sem escrever nenhuma resposta - usado tanto por authorizeScope
+// (handlers HTTP comuns) quanto por handlers que já fizeram upgrade da
+// conexão (ex.: WebSocket), onde escrever no http.ResponseWriter depois do
+// upgrade não é seguro. Quando a autenticação está desabilitada
+// (AUTH_TOKEN_KEY não configurada, modo dev), o escopo também é liberado -
+// mesmo comportamento "tudo liberado" de authMiddleware/csrfMiddleware nesse modo.
+func requestHasScope(r *http.Request, scope string) bool {
+	if _, ok, _ := authTokenKeys(); !ok {
+		return true
+	}
+
+	tok, ok := authTokenFromContext(r)
+	return ok && hasScope(tok.Scopes, scope)
+}
+
+// authorizeScope confirma o escopo via requestHasScope e, se ausente, escreve
+// a resposta 403 padrão no http.ResponseWriter.
+func authorizeScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if !requestHasScope(r, scope) {
+		respondMissingScope(w, scope)
+		return false
+	}
+	return true
+}
+
+// handleAuthScopes expõe os escopos do token atual junto com os escopos que
+// a política concederia ao usuário agora - os dois podem divergir se a
+// política foi recarregada depois que o token foi emitido, já que o token é
+// stateless e carrega os escopos do momento do login.
+func handleAuthScopes(w http.ResponseWriter, r *http.Request) {
+	tok, ok := authTokenFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":        tok.UserID,
+		"token_scopes":   tok.Scopes,
+		"current_scopes": scopesForUser(tok.UserID),
+	})
+}
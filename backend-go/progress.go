@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultChatProgressInterval é o intervalo padrão entre eventos "progress",
+// caso CHAT_PROGRESS_INTERVAL_MS não esteja configurada.
+const defaultChatProgressInterval = 2 * time.Second
+
+func chatProgressInterval() time.Duration {
+	if v := os.Getenv("CHAT_PROGRESS_INTERVAL_MS"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return defaultChatProgressInterval
+}
+
+// approxTokenCount estima tokens por contagem de palavras - não há
+// tokenizador no backend, e um erro de +-30% é aceitável para uma métrica de
+// progresso informativa, não cobrada.
+func approxTokenCount(text string) int {
+	return len(strings.Fields(text))
+}
+
+// progressTracker acumula o estado observável de um turno em andamento -
+// estágio atual e tokens de saída - para que emitProgress possa reportar um
+// snapshot consistente a cada tick sem recalcular a partir do zero.
+type progressTracker struct {
+	mu        sync.Mutex
+	stage     string
+	tokensOut int
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{stage: "thinking"}
+}
+
+// observeEvent atualiza o estágio e a contagem de tokens de saída conforme os
+// eventos do backend chegam. Nenhum backend atual emite sinal de tool_call,
+// então o estágio só alterna entre "thinking" (antes do primeiro texto) e
+// "writing" (a partir daí).
+func (t *progressTracker) observeEvent(evt sseEvent) {
+	if evt.eventType != "text" {
+		return
+	}
+	t.mu.Lock()
+	t.stage = "writing"
+	t.tokensOut += approxTokenCount(evt.content)
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) snapshot() (stage string, tokensOut int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stage, t.tokensOut
+}
+
+// emitProgress envia um sseEvent{type: "progress"} a cada chatProgressInterval()
+// até que done (o turno terminou) ou ctx.Done() (o turno foi cancelado) disparem.
+// O envio é best-effort: se o buffer de response estiver cheio, o tick é
+// descartado em vez de bloquear o processamento da fila.
+func emitProgress(sessionID string, msg queuedMessage, tracker *progressTracker, tokensIn int, start time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(chatProgressInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stage, tokensOut := tracker.snapshot()
+			evt := sseEvent{
+				eventType: "progress",
+				stage:     stage,
+				tokensIn:  tokensIn,
+				tokensOut: tokensOut,
+				elapsedMs: time.Since(start).Milliseconds(),
+				sessionID: &sessionID,
+			}
+			persistAndPublishEvent(sessionID, evt)
+			select {
+			case msg.response <- evt:
+			default:
+			}
+		case <-done:
+			return
+		case <-msg.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	queueMessagesBucket = []byte("messages")
+	queueEventsBucket   = []byte("events")
+	queueSeqBucket      = []byte("sequences")
+)
+
+// storedMessage é a representação persistida de uma queuedMessage, para que
+// a fila (hoje só em memória em getOrCreateQueue/processQueue) sobreviva a um
+// restart do processo.
+type storedMessage struct {
+	ID        string      `json:"id"`
+	SessionID string      `json:"session_id"`
+	Message   ChatRequest `json:"message"`
+	Status    string      `json:"status"` // pending, running, done, failed, cancelled
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// storedEvent é um sseEvent persistido com número de sequência monotônico
+// por sessão, para que GET /api/chat/resume possa reproduzir exatamente de
+// onde um cliente reconectando parou.
+type storedEvent struct {
+	Seq       uint64  `json:"seq"`
+	EventType string  `json:"event_type"`
+	Content   string  `json:"content"`
+	SessionID *string `json:"session_id,omitempty"`
+}
+
+// queueStore é o job store embutido (BoltDB) usado pela fila de mensagens.
+type queueStore struct {
+	db *bbolt.DB
+}
+
+func openQueueStore(path string) (*queueStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório do queue store: %w", err)
+	}
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir queue store: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{queueMessagesBucket, queueEventsBucket, queueSeqBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erro ao criar buckets do queue store: %w", err)
+	}
+
+	return &queueStore{db: db}, nil
+}
+
+func (s *queueStore) SaveMessage(msg storedMessage) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(queueMessagesBucket).Put([]byte(msg.ID), data)
+	})
+}
+
+func (s *queueStore) UpdateMessageStatus(id string, status string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(queueMessagesBucket)
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("mensagem não encontrada: %s", id)
+		}
+
+		var msg storedMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		msg.Status = status
+
+		updated, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), updated)
+	})
+}
+
+// UnfinishedMessages retorna mensagens que ainda não chegaram a
+// done/failed/cancelled -
+// usado na inicialização para reenfileirar jobs cujo processo dono morreu.
+func (s *queueStore) UnfinishedMessages() ([]storedMessage, error) {
+	var unfinished []storedMessage
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(queueMessagesBucket).ForEach(func(_, v []byte) error {
+			var msg storedMessage
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return nil
+			}
+			if msg.Status == "pending" || msg.Status == "running" {
+				unfinished = append(unfinished, msg)
+			}
+			return nil
+		})
+	})
+	return unfinished, err
+}
+
+func (s *queueStore) nextSeq(tx *bbolt.Tx, sessionID string) (uint64, error) {
+	b := tx.Bucket(queueSeqBucket)
+	seq := uint64(1)
+	if cur := b.Get([]byte(sessionID)); cur != nil {
+		seq = binary.BigEndian.Uint64(cur) + 1
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	if err := b.Put([]byte(sessionID), buf); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// AppendEvent persiste um evento com o próximo número de sequência da sessão.
+func (s *queueStore) AppendEvent(sessionID string, evt sseEvent) (storedEvent, error) {
+	var stored storedEvent
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		seq, err := s.nextSeq(tx, sessionID)
+		if err != nil {
+			return err
+		}
+
+		stored = storedEvent{Seq: seq, EventType: evt.eventType, Content: evt.content, SessionID: evt.sessionID}
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+
+		sessionEvents, err := tx.Bucket(queueEventsBucket).CreateBucketIfNotExists([]byte(sessionID))
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return sessionEvents.Put(key, data)
+	})
+
+	return stored, err
+}
+
+// EventsSince retorna os eventos de uma sessão com seq > fromSeq, em ordem.
+func (s *queueStore) EventsSince(sessionID string, fromSeq uint64) ([]storedEvent, error) {
+	var events []storedEvent
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(queueEventsBucket).Bucket([]byte(sessionID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if binary.BigEndian.Uint64(k) <= fromSeq {
+				return nil
+			}
+			var evt storedEvent
+			if err := json.Unmarshal(v, &evt); err != nil {
+				return nil
+			}
+			events = append(events, evt)
+			return nil
+		})
+	})
+
+	return events, err
+}
+
+func (s *queueStore) Close() error {
+	return s.db.Close()
+}
+
+// persistentQueueStore é global para simplificar o acesso a partir de
+// qualquer ponto da fila (enqueue, processQueue, o handler de resume); é nil
+// quando a inicialização do store falha, caso em que a persistência vira
+// no-op e o comportamento em memória de hoje é preservado.
+var persistentQueueStore *queueStore
+
+func initQueueStore() {
+	path := os.Getenv("QUEUE_STORE_PATH")
+	if path == "" {
+		path = filepath.Join(getClaudeBaseDir(), "queue.db")
+	}
+
+	store, err := openQueueStore(path)
+	if err != nil {
+		log.Printf("⚠️  Fila persistente desabilitada (%v) - usando apenas fila em memória", err)
+		return
+	}
+
+	persistentQueueStore = store
+	log.Printf("💾 Fila persistente aberta em: %s", path)
+}
+
+func markMessageRunning(id string) {
+	if persistentQueueStore == nil || id == "" {
+		return
+	}
+	if err := persistentQueueStore.UpdateMessageStatus(id, "running"); err != nil {
+		log.Printf("⚠️  Erro ao marcar mensagem como running: %v", err)
+	}
+}
+
+func markMessageDone(id string) {
+	if persistentQueueStore == nil || id == "" {
+		return
+	}
+	if err := persistentQueueStore.UpdateMessageStatus(id, "done"); err != nil {
+		log.Printf("⚠️  Erro ao marcar mensagem como done: %v", err)
+	}
+}
+
+func markMessageFailed(id string) {
+	if persistentQueueStore == nil || id == "" {
+		return
+	}
+	if err := persistentQueueStore.UpdateMessageStatus(id, "failed"); err != nil {
+		log.Printf("⚠️  Erro ao marcar mensagem como failed: %v", err)
+	}
+}
+
+// markMessageCancelled marca uma mensagem como cancelada (via DELETE
+// /api/chat/{session_id} ou desconexão do cliente) em vez de failed - o
+// output parcial já persistido por persistAndPublishEvent continua
+// inspecionável, mas a mensagem não é reenfileirada no próximo startup.
+func markMessageCancelled(id string) {
+	if persistentQueueStore == nil || id == "" {
+		return
+	}
+	if err := persistentQueueStore.UpdateMessageStatus(id, "cancelled"); err != nil {
+		log.Printf("⚠️  Erro ao marcar mensagem como cancelled: %v", err)
+	}
+}
+
+// persistAndPublishEvent grava o evento no store (se habilitado) e o entrega
+// a clientes reconectados via GET /api/chat/resume.
+func persistAndPublishEvent(sessionID string, evt sseEvent) {
+	if persistentQueueStore == nil {
+		return
+	}
+	stored, err := persistentQueueStore.AppendEvent(sessionID, evt)
+	if err != nil {
+		log.Printf("⚠️  Erro ao persistir evento: %v", err)
+		return
+	}
+	publishResumeEvent(sessionID, stored)
+}
+
+// enqueueChatMessage persiste a mensagem (se o store estiver habilitado),
+// enfileira-a na fila em memória da sessão e garante que a fila esteja sendo
+// processada. Compartilhado pelo handler SSE e pelo endpoint WebSocket.
+func enqueueChatMessage(ctx context.Context, req ChatRequest, sessionID string, projectDir string, sessionExists bool) <-chan sseEvent {
+	id := uuid.New().String()
+	eventChan := make(chan sseEvent, 100)
+
+	if persistentQueueStore != nil {
+		if err := persistentQueueStore.SaveMessage(storedMessage{
+			ID:        id,
+			SessionID: sessionID,
+			Message:   req,
+			Status:    "pending",
+			CreatedAt: time.Now(),
+		}); err != nil {
+			log.Printf("⚠️  Erro ao persistir mensagem na fila: %v", err)
+		}
+	}
+
+	queue := getOrCreateQueue(sessionID)
+	queue.enqueue(queuedMessage{id: id, message: req, response: eventChan, ctx: ctx, enqueuedAt: time.Now()})
+
+	if !queue.isProcessing() {
+		queue.setProcessing(true)
+		log.Printf("🚀 Iniciando processamento da fila para sessão: %s", sessionID)
+		go processQueue(sessionID, projectDir, sessionExists)
+	} else {
+		log.Printf("⏳ Sessão %s já está processando, mensagem enfileirada", sessionID)
+	}
+
+	return eventChan
+}
+
+// requeueOrphanedJobs reenfileira, na inicialização, jobs que ficaram em
+// pending/running quando o processo anterior morreu - eles nunca chegaram a
+// done/failed/cancelled, então o dono está necessariamente ausente.
+func requeueOrphanedJobs() {
+	if persistentQueueStore == nil {
+		return
+	}
+
+	unfinished, err := persistentQueueStore.UnfinishedMessages()
+	if err != nil {
+		log.Printf("⚠️  Erro ao verificar jobs órfãos: %v", err)
+		return
+	}
+
+	for _, msg := range unfinished {
+		log.Printf("♻️  Reenfileirando job órfão %s (sessão %s)", msg.ID, msg.SessionID)
+
+		_, projectDir, sessionExists := resolveChatSession(msg.Message)
+		ctx := context.Background()
+
+		queue := getOrCreateQueue(msg.SessionID)
+		eventChan := make(chan sseEvent, 100)
+		// Ninguém está escutando esse canal (é um replay de boot) - drenar para
+		// não vazar a goroutine produtora em processQueue.
+		go func() {
+			for range eventChan {
+			}
+		}()
+
+		queue.enqueue(queuedMessage{id: msg.ID, message: msg.Message, response: eventChan, ctx: ctx})
+		if !queue.isProcessing() {
+			queue.setProcessing(true)
+			go processQueue(msg.SessionID, projectDir, sessionExists)
+		}
+	}
+}
+
+// resumeBroadcaster distribui eventos recém-persistidos para clientes
+// conectados a GET /api/chat/resume, por sessão.
+var resumeBroadcaster = struct {
+	sync.RWMutex
+	subscribers map[string]map[chan storedEvent]struct{}
+}{subscribers: make(map[string]map[chan storedEvent]struct{})}
+
+func subscribeResume(sessionID string) chan storedEvent {
+	ch := make(chan storedEvent, 32)
+	resumeBroadcaster.Lock()
+	if resumeBroadcaster.subscribers[sessionID] == nil {
+		resumeBroadcaster.subscribers[sessionID] = make(map[chan storedEvent]struct{})
+	}
+	resumeBroadcaster.subscribers[sessionID][ch] = struct{}{}
+	resumeBroadcaster.Unlock()
+	return ch
+}
+
+func unsubscribeResume(sessionID string, ch chan storedEvent) {
+	resumeBroadcaster.Lock()
+	delete(resumeBroadcaster.subscribers[sessionID], ch)
+	resumeBroadcaster.Unlock()
+	close(ch)
+}
+
+func publishResumeEvent(sessionID string, evt storedEvent) {
+	resumeBroadcaster.RLock()
+	defer resumeBroadcaster.RUnlock()
+	for ch := range resumeBroadcaster.subscribers[sessionID] {
+		select {
+		case ch <- evt:
+		default:
+			log.Printf("⚠️  Subscriber de resume lento, descartando evento (sessão %s)", sessionID)
+		}
+	}
+}
+
+// handleChatResume reproduz eventos bufferados desde from_seq e então
+// continua a transmitir eventos novos enquanto a conexão seguir aberta, para
+// que um browser reconectando retome exatamente de onde parou, incluindo
+// eventos produzidos enquanto estava offline.
+func handleChatResume(w http.ResponseWriter, r *http.Request) {
+	if persistentQueueStore == nil {
+		http.Error(w, "fila persistente não habilitada", http.StatusServiceUnavailable)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "session_id é obrigatório", http.StatusBadRequest)
+		return
+	}
+
+	// Assim como handleEventsSSE em watcher.go exige project:read para o
+	// projeto transmitido, resolver o projeto da sessão e exigir project:read
+	// antes de transmitir - sem isso, qualquer usuário
+	// autenticado poderia reproduzir o histórico de chat de outro tenant só
+	// sabendo (ou adivinhando) o session_id.
+	project, _, ok := findSessionFile(sessionID)
+	if !ok {
+		http.Error(w, "Sessão não encontrada", http.StatusNotFound)
+		return
+	}
+	if !authorizeScope(w, r, projectScope("read", project)) {
+		return
+	}
+
+	fromSeq := uint64(0)
+	if v := r.URL.Query().Get("from_seq"); v != "" {
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "from_seq inválido", http.StatusBadRequest)
+			return
+		}
+		fromSeq = parsed
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	buffered, err := persistentQueueStore.EventsSince(sessionID, fromSeq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("erro ao ler eventos bufferizados: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	live := subscribeResume(sessionID)
+	defer unsubscribeResume(sessionID, live)
+
+	for _, evt := range buffered {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case evt, ok := <-live:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
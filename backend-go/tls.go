@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsDomains retorna os domínios configurados em TLS_DOMAINS (separados por
+// vírgula), ou nil quando TLS não está habilitado - nesse caso o servidor
+// continua em HTTP puro, como hoje.
+func tlsDomains() []string {
+	raw := os.Getenv("TLS_DOMAINS")
+	if raw == "" {
+		return nil
+	}
+
+	var domains []string
+	for _, d := range strings.Split(raw, ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// hstsMiddleware adiciona Strict-Transport-Security quando a requisição chega via TLS.
+func hstsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withHTTPSVariants adiciona a variante https:// de cada origem http:// já
+// permitida, para que o CORS continue funcionando quando o backend passa a
+// ser exposto diretamente via TLS, sem proxy reverso na frente.
+func withHTTPSVariants(origins []string) []string {
+	all := make([]string, 0, len(origins)*2)
+	for _, origin := range origins {
+		all = append(all, origin)
+		if strings.HasPrefix(origin, "http://") {
+			all = append(all, "https://"+strings.TrimPrefix(origin, "http://"))
+		}
+	}
+	return all
+}
+
+// serve inicia o(s) listener(s) HTTP/HTTPS. Quando TLS_DOMAINS está
+// configurado, serve HTTPS em :8443 via autocert (ACME/Let's Encrypt, cache em
+// disco sob getClaudeBaseDir()/certs) e mantém um listener HTTP em :8000 que
+// responde o desafio http-01 e redireciona o restante para HTTPS. Sem
+// TLS_DOMAINS, o comportamento é idêntico ao de hoje: HTTP puro em :8000.
+func serve(handler http.Handler) error {
+	domains := tlsDomains()
+	if len(domains) == 0 {
+		log.Println("✅ Servidor rodando em http://localhost:8000 (sem TLS)")
+		return http.ListenAndServe(":8000", handler)
+	}
+
+	certCacheDir := filepath.Join(getClaudeBaseDir(), "certs")
+	if err := os.MkdirAll(certCacheDir, 0700); err != nil {
+		return err
+	}
+
+	certManager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(certCacheDir),
+	}
+
+	httpsServer := &http.Server{
+		Addr:      ":8443",
+		Handler:   hstsMiddleware(handler),
+		TLSConfig: certManager.TLSConfig(),
+	}
+
+	go func() {
+		log.Printf("✅ Servidor HTTPS rodando em :8443 (ACME: %s)", strings.Join(domains, ", "))
+		if err := httpsServer.ListenAndServeTLS("", ""); err != nil {
+			log.Fatalf("Erro no servidor HTTPS: %v", err)
+		}
+	}()
+
+	// Listener HTTP: responde o desafio ACME http-01 e 301-redireciona o resto para HTTPS
+	redirectHandler := certManager.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}))
+
+	log.Println("✅ Servidor HTTP (desafio ACME + redirect para HTTPS) rodando em :8000")
+	return http.ListenAndServe(":8000", redirectHandler)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fsDebounceInterval agrupa gravações rápidas sucessivas no mesmo arquivo -
+// o Claude escreve um .jsonl linha a linha, e cada linha dispara um evento
+// fsnotify.Write separado.
+const fsDebounceInterval = 300 * time.Millisecond
+
+// fsEvent é um evento tipado emitido pelo watcher para os clientes inscritos
+// em GET /api/events, substituindo o atual padrão de invalidação de cache via
+// chamada HTTP por um modelo push.
+type fsEvent struct {
+	Type      string      `json:"type"` // session.created, session.appended, session.deleted, project.created
+	Project   string      `json:"project"`
+	SessionID string      `json:"session_id,omitempty"`
+	Message   interface{} `json:"message,omitempty"`
+}
+
+// eventSubscriber representa um cliente inscrito nos eventos do watcher, com
+// filtro opcional por projeto e/ou sessão vindo dos query params da conexão.
+type eventSubscriber struct {
+	ch        chan fsEvent
+	project   string
+	sessionID string
+}
+
+var eventBroadcaster = struct {
+	sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+}{
+	subscribers: make(map[*eventSubscriber]struct{}),
+}
+
+func subscribeEvents(project, sessionID string) *eventSubscriber {
+	sub := &eventSubscriber{ch: make(chan fsEvent, 32), project: project, sessionID: sessionID}
+	eventBroadcaster.Lock()
+	eventBroadcaster.subscribers[sub] = struct{}{}
+	eventBroadcaster.Unlock()
+	return sub
+}
+
+func unsubscribeEvents(sub *eventSubscriber) {
+	eventBroadcaster.Lock()
+	delete(eventBroadcaster.subscribers, sub)
+	eventBroadcaster.Unlock()
+	close(sub.ch)
+}
+
+// publishEvent entrega o evento a cada subscriber cujo filtro combine. Um
+// subscriber lento tem o evento descartado em vez de travar o watcher.
+func publishEvent(evt fsEvent) {
+	eventBroadcaster.RLock()
+	defer eventBroadcaster.RUnlock()
+
+	for sub := range eventBroadcaster.subscribers {
+		if sub.project != "" && sub.project != evt.Project {
+			continue
+		}
+		if sub.sessionID != "" && sub.sessionID != evt.SessionID {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			log.Printf("⚠️  Subscriber de eventos lento, descartando evento %s", evt.Type)
+		}
+	}
+}
+
+// sessionFileWatcher rastreia, por arquivo de sessão, até onde já foi lido e
+// debouncea gravações rápidas antes de reprocessar o que foi adicionado.
+type sessionFileWatcher struct {
+	mu       sync.Mutex
+	offsets  map[string]int64
+	debounce map[string]*time.Timer
+}
+
+var claudeFileWatcher = &sessionFileWatcher{
+	offsets:  make(map[string]int64),
+	debounce: make(map[string]*time.Timer),
+}
+
+func (w *sessionFileWatcher) onWrite(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timer, ok := w.debounce[path]; ok {
+		timer.Reset(fsDebounceInterval)
+		return
+	}
+
+	w.debounce[path] = time.AfterFunc(fsDebounceInterval, func() {
+		w.mu.Lock()
+		delete(w.debounce, path)
+		w.mu.Unlock()
+		w.processAppend(path)
+	})
+}
+
+// processAppend lê apenas o que foi escrito desde a última leitura e emite um
+// evento tipado por linha JSON nova.
+func (w *sessionFileWatcher) processAppend(path string) {
+	w.mu.Lock()
+	offset := w.offsets[path]
+	w.mu.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() <= offset {
+		return
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	project, sessionID := projectAndSessionFromPath(path)
+	eventType := "session.appended"
+	if offset == 0 {
+		eventType = "session.created"
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := decryptSessionLineTransparent(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var message interface{}
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			message = nil
+		}
+
+		publishEvent(fsEvent{Type: eventType, Project: project, SessionID: sessionID, Message: message})
+		eventType = "session.appended"
+	}
+
+	w.mu.Lock()
+	w.offsets[path] = info.Size()
+	w.mu.Unlock()
+}
+
+func projectAndSessionFromPath(path string) (project string, sessionID string) {
+	project = filepath.Base(filepath.Dir(path))
+	sessionID = strings.TrimSuffix(filepath.Base(path), ".jsonl")
+	return project, sessionID
+}
+
+// startFilesystemWatcher observa getClaudeProjectsDir() e
+// getClaudeProjetosDir() recursivamente e publica eventos de sessão/projeto
+// para os clientes inscritos em /api/events.
+func startFilesystemWatcher() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("❌ Erro ao iniciar watcher de arquivos: %v", err)
+		return
+	}
+
+	for _, root := range []string{getClaudeProjectsDir(), getClaudeProjetosDir()} {
+		addWatchRecursive(watcher, root)
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				handleFSEvent(watcher, event)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("❌ Erro no watcher: %v", err)
+			}
+		}
+	}()
+
+	log.Println("👀 Watcher de projetos Claude iniciado")
+}
+
+// addWatchRecursive adiciona um watch em cada subdiretório, já que fsnotify
+// não observa recursivamente por conta própria.
+func addWatchRecursive(watcher *fsnotify.Watcher, root string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				log.Printf("⚠️  Erro ao observar %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+func handleFSEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := watcher.Add(event.Name); err != nil {
+				log.Printf("⚠️  Erro ao observar novo diretório %s: %v", event.Name, err)
+			}
+			publishEvent(fsEvent{Type: "project.created", Project: filepath.Base(event.Name)})
+			return
+		}
+	}
+
+	if filepath.Ext(event.Name) != ".jsonl" {
+		return
+	}
+
+	switch {
+	case event.Op&(fsnotify.Write|fsnotify.Create) != 0:
+		project, _ := projectAndSessionFromPath(event.Name)
+		invalidateSessionCache(project)
+		claudeFileWatcher.onWrite(event.Name)
+	case event.Op&fsnotify.Remove != 0:
+		project, sessionID := projectAndSessionFromPath(event.Name)
+		invalidateSessionCache(project)
+		publishEvent(fsEvent{Type: "session.deleted", Project: project, SessionID: sessionID})
+	}
+}
+
+// handleEventsSSE expõe os eventos do watcher como SSE, filtrados por
+// ?project= (obrigatório) e opcionalmente por ?session_id=, com heartbeat a
+// cada 15s para evitar que proxies derrubem a conexão por ociosidade.
+//
+// handleEventsSSE transmite os eventos de filesystem (criação/atualização de
+// sessão) em push. Um filtro de projeto é obrigatório - sem ele o stream
+// exporia o conteúdo de mensagens de todos os projetos a qualquer chamador
+// com um único escopo de leitura, então exigimos project:read:<project> para
+// o projeto filtrado.
+func handleEventsSSE(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		http.Error(w, "parâmetro 'project' é obrigatório", http.StatusBadRequest)
+		return
+	}
+	if !authorizeScope(w, r, projectScope("read", project)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := subscribeEvents(project, r.URL.Query().Get("session_id"))
+	defer unsubscribeEvents(sub)
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case evt, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait     = 10 * time.Second
+	wsPongWait      = 60 * time.Second
+	wsPingInterval  = (wsPongWait * 9) / 10
+	wsSendBufferCap = 64 // backpressure: cliente lento não trava o worker da fila
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return allowedOrigins[r.Header.Get("Origin")]
+	},
+}
+
+// wsInboundFrame representa um frame recebido do cliente via WebSocket
+type wsInboundFrame struct {
+	Type        string  `json:"type"`
+	Content     string  `json:"content"`
+	SessionID   *string `json:"session_id"`
+	ProjectName *string `json:"project_name"`
+}
+
+// wsOutboundFrame representa um frame enviado ao cliente via WebSocket
+type wsOutboundFrame struct {
+	Type      string  `json:"type"` // "text", "done", "error", "typing"
+	Content   string  `json:"content,omitempty"`
+	SessionID *string `json:"session_id,omitempty"`
+}
+
+// handleChatWebSocket faz upgrade da conexão e multiplexa a mesma infraestrutura
+// de fila (getOrCreateQueue/processQueue) usada pelo handler SSE, mas sem a
+// limitação de uma conexão por turno: o socket fica aberto entre mensagens.
+func handleChatWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("❌ Erro no upgrade WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("🔌 WebSocket conectado: %s", r.RemoteAddr)
+
+	send := make(chan wsOutboundFrame, wsSendBufferCap)
+	done := make(chan struct{})
+	go wsWritePump(conn, send, done)
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	var cancelMu sync.Mutex
+	var cancelCurrent context.CancelFunc
+
+	defer func() {
+		cancelMu.Lock()
+		if cancelCurrent != nil {
+			cancelCurrent()
+		}
+		cancelMu.Unlock()
+		close(done)
+		log.Printf("🔌 WebSocket desconectado: %s", r.RemoteAddr)
+	}()
+
+	for {
+		var inbound wsInboundFrame
+		if err := conn.ReadJSON(&inbound); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("⚠️  WebSocket fechado inesperadamente: %v", err)
+			}
+			return
+		}
+
+		if inbound.Type != "message" {
+			continue
+		}
+
+		sanitized, err := sanitizeMessage(inbound.Content)
+		if err != nil {
+			wsTrySend(send, done, wsOutboundFrame{Type: "error", Content: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+
+		wsProjectName := "home"
+		if inbound.ProjectName != nil && *inbound.ProjectName != "" {
+			wsProjectName = *inbound.ProjectName
+		}
+		if !requestHasScope(r, "chat:send") || !requestHasScope(r, projectScope("write", wsProjectName)) {
+			wsTrySend(send, done, wsOutboundFrame{Type: "error", Content: "missing_scope"})
+			continue
+		}
+
+		req := ChatRequest{Message: sanitized, SessionID: inbound.SessionID, ProjectName: inbound.ProjectName}
+		sessionID, projectDir, sessionExists := resolveChatSession(req)
+
+		// Cancelar o turno em andamento (se houver) se o socket fechar no meio da resposta
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelMu.Lock()
+		cancelCurrent = cancel
+		cancelMu.Unlock()
+
+		wsTrySend(send, done, wsOutboundFrame{Type: "typing", SessionID: &sessionID})
+
+		eventChan := enqueueChatMessage(ctx, req, sessionID, projectDir, sessionExists)
+
+	drain:
+		for {
+			select {
+			case event, ok := <-eventChan:
+				if !ok {
+					break drain
+				}
+				out := wsOutboundFrame{SessionID: event.sessionID}
+				switch event.eventType {
+				case "text":
+					out.Type = "text"
+					out.Content = event.content
+				case "error":
+					out.Type = "error"
+					out.Content = event.content
+				case "done":
+					out.Type = "done"
+				default:
+					continue
+				}
+				if !wsTrySend(send, done, out) {
+					cancel()
+					return
+				}
+			case <-done:
+				cancel()
+				return
+			}
+		}
+
+		cancelMu.Lock()
+		cancelCurrent = nil
+		cancelMu.Unlock()
+
+		if req.ProjectName != nil && *req.ProjectName != "" {
+			invalidateSessionCache(*req.ProjectName)
+		}
+	}
+}
+
+// wsTrySend envia um frame respeitando o buffer de backpressure; retorna false
+// se a conexão já foi encerrada enquanto o envio era tentado.
+func wsTrySend(send chan<- wsOutboundFrame, done <-chan struct{}, frame wsOutboundFrame) bool {
+	select {
+	case send <- frame:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// wsWritePump é a única goroutine que escreve no socket (gorilla/websocket não
+// permite escritas concorrentes), com ping/pong keepalive para evitar que
+// proxies derrubem a conexão por ociosidade.
+func wsWritePump(conn *websocket.Conn, send <-chan wsOutboundFrame, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				log.Printf("❌ Erro ao escrever no WebSocket: %v", err)
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}